@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"lenslocked.com/controllers"
+	"lenslocked.com/cookie"
+	"lenslocked.com/hash"
+	"lenslocked.com/jobs"
+	"lenslocked.com/models"
+	"lenslocked.com/storage"
+)
+
+// PostgresConfig holds everything needed to connect to our database
+type PostgresConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+}
+
+func (c PostgresConfig) ConnectionInfo() string {
+	if c.Password == "" {
+		return fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
+			c.Host, c.Port, c.User, c.Name)
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		c.Host, c.Port, c.User, c.Password, c.Name)
+}
+
+func DefaultPostgresConfig() PostgresConfig {
+	return PostgresConfig{
+		Host: "localhost",
+		Port: 5432,
+		User: "godwin",
+		Name: "lenslockedDb_dev",
+	}
+}
+
+// MailerConfig selects how outgoing emails are delivered. When
+// SMTPHost is empty emails are only logged to stdout.
+type MailerConfig struct {
+	FromName     string `json:"from_name"`
+	FromEmail    string `json:"from_email"`
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password"`
+}
+
+func DefaultMailerConfig() MailerConfig {
+	return MailerConfig{
+		FromName:  "Lenslocked Support",
+		FromEmail: "support@lenslocked.com",
+		SMTPPort:  587,
+	}
+}
+
+// PasswordConfig picks the algorithm and costs used to hash
+// new passwords. Hashes made with other algorithms or costs
+// keep working and are upgraded when their user logs in.
+type PasswordConfig struct {
+	// Algorithm is either "argon2id" or "bcrypt"
+	Algorithm     string `json:"algorithm"`
+	BcryptCost    int    `json:"bcrypt_cost"`
+	Argon2Time    uint32 `json:"argon2_time"`
+	Argon2Memory  uint32 `json:"argon2_memory_kib"`
+	Argon2Threads uint8  `json:"argon2_threads"`
+}
+
+func DefaultPasswordConfig() PasswordConfig {
+	return PasswordConfig{
+		Algorithm:     "argon2id",
+		BcryptCost:    bcrypt.DefaultCost,
+		Argon2Time:    hash.DefaultArgon2Params.Time,
+		Argon2Memory:  hash.DefaultArgon2Params.Memory,
+		Argon2Threads: hash.DefaultArgon2Params.Threads,
+	}
+}
+
+// Hasher returns a PasswordHasher that hashes with the
+// configured algorithm and can verify hashes made by either.
+func (c PasswordConfig) Hasher() hash.PasswordHasher {
+	bc := hash.NewBcrypt(c.BcryptCost)
+	argon := hash.NewArgon2id(hash.Argon2Params{
+		Time:    c.Argon2Time,
+		Memory:  c.Argon2Memory,
+		Threads: c.Argon2Threads,
+	})
+	if c.Algorithm == "bcrypt" {
+		return hash.NewPasswords(bc, argon)
+	}
+	return hash.NewPasswords(argon, bc)
+}
+
+// HMACConfig holds the keys used to hash and sign tokens. New
+// digests use PrimaryKeyID; the other keys are only used to
+// verify digests made before the primary key was rotated. The
+// key ID "" verifies digests stored without a key ID, from
+// before keys could be rotated at all.
+type HMACConfig struct {
+	PrimaryKeyID string            `json:"primary_key_id"`
+	Keys         map[string]string `json:"keys"`
+}
+
+func DefaultHMACConfig() HMACConfig {
+	return HMACConfig{
+		PrimaryKeyID: "1",
+		Keys: map[string]string{
+			"1": "secret-hmac-key",
+			"":  "secret-hmac-key",
+		},
+	}
+}
+
+// Keyring builds the keyring described by the config, and
+// panics if the config is invalid.
+func (c HMACConfig) Keyring() *hash.Keyring {
+	kr, err := hash.NewKeyring(c.PrimaryKeyID, c.Keys)
+	if err != nil {
+		panic(err)
+	}
+	return kr
+}
+
+// CookieConfig controls the attributes of the cookies that
+// keep people logged in. Secure should be turned on whenever
+// the site is served over HTTPS.
+type CookieConfig struct {
+	Secure bool   `json:"secure"`
+	Domain string `json:"domain"`
+	// SameSite is one of "lax", "strict" or "none"
+	SameSite string `json:"same_site"`
+	// SessionTimeoutHours is how long users who didn't tick
+	// "remember me" stay logged in without visiting the site
+	SessionTimeoutHours int `json:"session_timeout_hours"`
+	// RememberDays is how long users who ticked "remember me"
+	// stay logged in without visiting the site
+	RememberDays int `json:"remember_days"`
+}
+
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		SameSite:            "lax",
+		SessionTimeoutHours: 12,
+		RememberDays:        30,
+	}
+}
+
+// Manager builds the cookie manager described by the config
+func (c CookieConfig) Manager() *cookie.Manager {
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+	return cookie.NewManager(cookie.Config{
+		Secure:         c.Secure,
+		SameSite:       sameSite,
+		Domain:         c.Domain,
+		SessionTimeout: time.Duration(c.SessionTimeoutHours) * time.Hour,
+		RememberFor:    time.Duration(c.RememberDays) * 24 * time.Hour,
+	})
+}
+
+// StorageConfig picks where files such as uploaded images are
+// kept: in Dir on the local disk, or in an S3-compatible bucket
+type StorageConfig struct {
+	// Backend is either "local" or "s3"
+	Backend string          `json:"backend"`
+	Dir     string          `json:"dir"`
+	S3      S3StorageConfig `json:"s3"`
+}
+
+type S3StorageConfig struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+func DefaultStorageConfig() StorageConfig {
+	return StorageConfig{
+		Backend: "local",
+		Dir:     "images",
+	}
+}
+
+// Blob builds the storage backend described by the config,
+// and panics if the config is invalid.
+func (c StorageConfig) Blob() storage.Blob {
+	switch c.Backend {
+	case "s3":
+		blob, err := storage.NewS3(storage.S3Config{
+			Endpoint:        c.S3.Endpoint,
+			Region:          c.S3.Region,
+			Bucket:          c.S3.Bucket,
+			AccessKeyID:     c.S3.AccessKeyID,
+			SecretAccessKey: c.S3.SecretAccessKey,
+		})
+		if err != nil {
+			panic(err)
+		}
+		return blob
+	case "local", "":
+		return storage.NewLocal(c.Dir)
+	default:
+		panic(fmt.Sprintf("unknown storage backend %q", c.Backend))
+	}
+}
+
+// ImagesConfig controls how large uploaded images can be, and
+// the resized variants made of them
+type ImagesConfig struct {
+	// MaxFileMB is the size limit of a single image
+	MaxFileMB int64 `json:"max_file_mb"`
+	// MaxRequestMB is the size limit of any request, and so of
+	// all of the images uploaded at once
+	MaxRequestMB int64 `json:"max_request_mb"`
+	// Variants are made of every uploaded image. After changing
+	// them, run the app with -regenerate-variants.
+	Variants []VariantConfig `json:"variants"`
+}
+
+// VariantConfig is a size images are resized to fit within
+type VariantConfig struct {
+	Name      string `json:"name"`
+	MaxWidth  int    `json:"max_width"`
+	MaxHeight int    `json:"max_height"`
+	// Quality is the JPEG quality, from 1 to 100
+	Quality int `json:"quality"`
+}
+
+func DefaultImagesConfig() ImagesConfig {
+	return ImagesConfig{
+		MaxFileMB:    20,
+		MaxRequestMB: 200,
+		Variants: []VariantConfig{
+			{Name: "thumbnail", MaxWidth: 400, MaxHeight: 400, Quality: 80},
+			{Name: "medium", MaxWidth: 1200, MaxHeight: 1200, Quality: 85},
+			{Name: "large", MaxWidth: 2400, MaxHeight: 2400, Quality: 85},
+		},
+	}
+}
+
+// Presets returns the variants in the form the ImageService
+// takes them
+func (c ImagesConfig) Presets() []models.VariantPreset {
+	presets := make([]models.VariantPreset, len(c.Variants))
+	for i, v := range c.Variants {
+		presets[i] = models.VariantPreset{
+			Name:      v.Name,
+			MaxWidth:  v.MaxWidth,
+			MaxHeight: v.MaxHeight,
+			Quality:   v.Quality,
+		}
+	}
+	return presets
+}
+
+// JobsConfig controls the workers that run background jobs,
+// such as sending emails and resizing images
+type JobsConfig struct {
+	Workers int `json:"workers"`
+	// MaxAttempts is how many times a job is tried before it is
+	// given up on and marked dead
+	MaxAttempts    int `json:"max_attempts"`
+	TimeoutMinutes int `json:"timeout_minutes"`
+	// ShutdownSeconds is how long running jobs get to finish
+	// when the app is stopped. Jobs that don't are run again
+	// after the next start.
+	ShutdownSeconds int `json:"shutdown_seconds"`
+}
+
+func DefaultJobsConfig() JobsConfig {
+	return JobsConfig{
+		Workers:         4,
+		MaxAttempts:     10,
+		TimeoutMinutes:  5,
+		ShutdownSeconds: 30,
+	}
+}
+
+// Queue returns the config of the job queue
+func (c JobsConfig) Queue() jobs.Config {
+	return jobs.Config{
+		Workers:     c.Workers,
+		MaxAttempts: c.MaxAttempts,
+		Timeout:     time.Duration(c.TimeoutMinutes) * time.Minute,
+	}
+}
+
+type Config struct {
+	Port    int    `json:"port"`
+	Env     string `json:"env"`
+	BaseURL string `json:"base_url"`
+	Pepper  string `json:"pepper"`
+	// CSRFKey signs CSRF tokens and has to be 32 bytes long
+	CSRFKey string       `json:"csrf_key"`
+	HMAC    HMACConfig   `json:"hmac"`
+	Cookie  CookieConfig `json:"cookie"`
+	// Login is "password", "magic" or "both", and picks how
+	// users can log in
+	Login string `json:"login"`
+	// InviteOnly requires an invitation to sign up
+	InviteOnly bool           `json:"invite_only"`
+	Password   PasswordConfig `json:"password"`
+	Database   PostgresConfig `json:"database"`
+	Mailer     MailerConfig   `json:"mailer"`
+	Images     ImagesConfig   `json:"images"`
+	Storage    StorageConfig  `json:"storage"`
+	Jobs       JobsConfig     `json:"jobs"`
+}
+
+func (c Config) IsProd() bool {
+	return c.Env == "prod"
+}
+
+// LoginMethods returns the ways users can log in
+func (c Config) LoginMethods() controllers.LoginMethods {
+	switch c.Login {
+	case "magic":
+		return controllers.LoginMethods{MagicLink: true}
+	case "both":
+		return controllers.LoginMethods{Password: true, MagicLink: true}
+	default:
+		return controllers.LoginMethods{Password: true}
+	}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Port:     3000,
+		Env:      "dev",
+		BaseURL:  "http://localhost:3000",
+		Pepper:   "secret-random-string",
+		CSRFKey:  "secret-csrf-key-of-32-bytes-long",
+		HMAC:     DefaultHMACConfig(),
+		Cookie:   DefaultCookieConfig(),
+		Login:    "password",
+		Password: DefaultPasswordConfig(),
+		Database: DefaultPostgresConfig(),
+		Mailer:   DefaultMailerConfig(),
+		Images:   DefaultImagesConfig(),
+		Storage:  DefaultStorageConfig(),
+		Jobs:     DefaultJobsConfig(),
+	}
+}
+
+// LoadConfig reads the .config file in the working directory.
+// If the file is missing the default (development) config is
+// used, unless configReq is true in which case we panic.
+func LoadConfig(configReq bool) Config {
+	f, err := os.Open(".config")
+	if err != nil {
+		if configReq {
+			panic(err)
+		}
+		fmt.Println("Using the default config...")
+		return DefaultConfig()
+	}
+	defer f.Close()
+	c := DefaultConfig()
+	// decoding merges maps, so clear the default keys to make
+	// sure they never end up in a real keyring
+	c.HMAC = HMACConfig{}
+	dec := json.NewDecoder(f)
+	if err := dec.Decode(&c); err != nil {
+		panic(err)
+	}
+	if len(c.HMAC.Keys) == 0 {
+		// the default keys are public, so they are only good
+		// enough for development
+		if c.IsProd() {
+			panic("hmac keys must be set in production")
+		}
+		c.HMAC = DefaultHMACConfig()
+	}
+	if len(c.CSRFKey) != 32 {
+		panic("csrf_key must be 32 bytes long")
+	}
+	fmt.Println("Successfully loaded .config")
+	return c
+}
@@ -0,0 +1,67 @@
+package context
+
+import (
+	"context"
+
+	"lenslocked.com/models"
+)
+
+// privateKey is unexported so no other package can
+// collide with the values we store in a context.
+type privateKey string
+
+const (
+	userKey     privateKey = "user"
+	sessionKey  privateKey = "session"
+	apiTokenKey privateKey = "api_token"
+)
+
+// WithUser returns a copy of ctx that carries the provided user
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// User returns the user stored in ctx, or nil if there
+// is no logged in user attached to it
+func User(ctx context.Context) *models.User {
+	if temp := ctx.Value(userKey); temp != nil {
+		if user, ok := temp.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// WithSession returns a copy of ctx that carries the session
+// the current user is logged in with
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+// Session returns the session stored in ctx, or nil if there
+// is no logged in user attached to it
+func Session(ctx context.Context) *models.Session {
+	if temp := ctx.Value(sessionKey); temp != nil {
+		if session, ok := temp.(*models.Session); ok {
+			return session
+		}
+	}
+	return nil
+}
+
+// WithAPIToken returns a copy of ctx that carries the personal
+// access token the current request was authenticated with
+func WithAPIToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey, token)
+}
+
+// APIToken returns the personal access token stored in ctx, or
+// nil if the request wasn't made with one
+func APIToken(ctx context.Context) *models.APIToken {
+	if temp := ctx.Value(apiTokenKey); temp != nil {
+		if token, ok := temp.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
@@ -0,0 +1,742 @@
+package controllers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"lenslocked.com/context"
+	"lenslocked.com/cookie"
+	"lenslocked.com/email"
+	"lenslocked.com/models"
+	"lenslocked.com/policy"
+	"lenslocked.com/views"
+)
+
+// NewAccount is used to create a new Account controller.
+// This function will panic if the templates are not
+// parsed correctly, and should only be used during initial setup
+func NewAccount(us models.UserService, ss models.SessionService, ts models.APITokenService, is models.InvitationService, cookies *cookie.Manager, emailer *email.Client) *Account {
+	return &Account{
+		SettingsView:      views.NewView("bootstrap", "account/settings"),
+		SessionsView:      views.NewView("bootstrap", "account/sessions"),
+		TwoFactorView:     views.NewView("bootstrap", "account/two_factor"),
+		RecoveryCodesView: views.NewView("bootstrap", "account/recovery_codes"),
+		DeleteView:        views.NewView("bootstrap", "account/delete"),
+		RestoreView:       views.NewView("bootstrap", "account/restore"),
+		TokensView:        views.NewView("bootstrap", "account/tokens"),
+		InvitationsView:   views.NewView("bootstrap", "account/invitations"),
+		us:                us,
+		ss:                ss,
+		ts:                ts,
+		is:                is,
+		cookies:           cookies,
+		emailer:           emailer,
+	}
+}
+
+// Account holds the pages a logged in user uses to manage
+// their own account
+type Account struct {
+	SettingsView      *views.View
+	SessionsView      *views.View
+	TwoFactorView     *views.View
+	RecoveryCodesView *views.View
+	DeleteView        *views.View
+	RestoreView       *views.View
+	TokensView        *views.View
+	InvitationsView   *views.View
+	us                models.UserService
+	ss                models.SessionService
+	ts                models.APITokenService
+	is                models.InvitationService
+	cookies           *cookie.Manager
+	emailer           *email.Client
+}
+
+// AccountSettings is the data the settings page renders. Each
+// of its forms posts to its own endpoint.
+type AccountSettings struct {
+	Name         string
+	Email        string
+	PendingEmail string
+	Errors       views.FieldErrors
+}
+
+func newAccountSettings(user *models.User) *AccountSettings {
+	return &AccountSettings{
+		Name:         user.Name,
+		Email:        user.Email,
+		PendingEmail: user.PendingEmail,
+		Errors:       views.FieldErrors{},
+	}
+}
+
+// setError shows err next to the field it is about, or as an
+// alert if it isn't about any field in particular
+func (as *AccountSettings) setError(vd *views.Data, err error) {
+	switch err {
+	case models.ErrEmailRequired, models.ErrEmailInvalid, models.ErrEmailTaken:
+		as.Errors.Set("email", err)
+	case models.ErrPasswordInCorrect:
+		as.Errors.Set("current_password", err)
+	case models.ErrPasswordRequired, models.ErrPasswordTooShort:
+		as.Errors.Set("new_password", err)
+	default:
+		vd.SetAlert(err)
+	}
+}
+
+// Settings shows the forms used to change the current user's
+// name, email address and password
+//
+// GET /account
+func (a *Account) Settings(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield = newAccountSettings(context.User(r.Context()))
+	a.SettingsView.Render(w, r, vd)
+}
+
+type ProfileForm struct {
+	Name string `schema:"name"`
+}
+
+// UpdateProfile changes the current user's name
+//
+// POST /account/profile
+func (a *Account) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ProfileForm
+	user := context.User(r.Context())
+	settings := newAccountSettings(user)
+	vd.Yield = settings
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	user.Name = form.Name
+	if err := a.us.Update(user); err != nil {
+		settings.setError(&vd, err)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Your profile has been updated.",
+	})
+}
+
+type EmailForm struct {
+	Email           string `schema:"email"`
+	CurrentPassword string `schema:"current_password"`
+}
+
+// UpdateEmail starts changing the current user's email. The
+// new address only replaces the old one once the user follows
+// the verification link we send to it.
+//
+// POST /account/email
+func (a *Account) UpdateEmail(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form EmailForm
+	user := context.User(r.Context())
+	settings := newAccountSettings(user)
+	vd.Yield = settings
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	user, err := a.us.Authenticate(user.Email, form.CurrentPassword)
+	if err != nil {
+		settings.setError(&vd, err)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	user.Email = form.Email
+	if err := a.us.Update(user); err != nil {
+		settings.Email = form.Email
+		settings.setError(&vd, err)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	if user.PendingEmail == "" {
+		views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelInfo,
+			Message: "That is already your email address.",
+		})
+		return
+	}
+	sendVerification(a.us, a.emailer, user)
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Check your new inbox for a link to confirm the change. Until then we'll keep using your current address.",
+	})
+}
+
+type PasswordForm struct {
+	CurrentPassword string `schema:"current_password"`
+	NewPassword     string `schema:"new_password"`
+}
+
+// UpdatePassword changes the current user's password and logs
+// out every other session they have.
+//
+// POST /account/password
+func (a *Account) UpdatePassword(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form PasswordForm
+	user := context.User(r.Context())
+	settings := newAccountSettings(user)
+	vd.Yield = settings
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	user, err := a.us.Authenticate(user.Email, form.CurrentPassword)
+	if err != nil {
+		settings.setError(&vd, err)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	if form.NewPassword == "" {
+		settings.setError(&vd, models.ErrPasswordRequired)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	user.Password = form.NewPassword
+	if err := a.us.Update(user); err != nil {
+		settings.setError(&vd, err)
+		a.SettingsView.Render(w, r, vd)
+		return
+	}
+	if err := a.revokeOtherSessions(r); err != nil {
+		log.Println(err)
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Your password has been changed and your other devices have been logged out.",
+	})
+}
+
+// revokeOtherSessions logs out every session of the current
+// user except the one the request was made with
+func (a *Account) revokeOtherSessions(r *http.Request) error {
+	user := context.User(r.Context())
+	current := context.Session(r.Context())
+	sessions, err := a.ss.ByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if current != nil && s.ID == current.ID {
+			continue
+		}
+		if err := a.ss.Delete(s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SessionRow is a single entry in the list of active sessions
+type SessionRow struct {
+	models.Session
+	Current bool
+}
+
+// Sessions lists every active session of the current user
+//
+// GET /account/sessions
+func (a *Account) Sessions(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	current := context.Session(r.Context())
+	sessions, err := a.ss.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.SessionsView.Render(w, r, vd)
+		return
+	}
+	rows := make([]SessionRow, len(sessions))
+	for i, s := range sessions {
+		rows[i] = SessionRow{
+			Session: s,
+			Current: current != nil && s.ID == current.ID,
+		}
+	}
+	vd.Yield = rows
+	a.SessionsView.Render(w, r, vd)
+}
+
+// RevokeSession logs out a single session of the current user
+//
+// POST /account/sessions/:id/revoke
+func (a *Account) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusNotFound)
+		return
+	}
+	session, err := a.ss.ByID(uint(id))
+	if err != nil || !policy.CanRevokeSession(user, session) {
+		// don't tell users whether other people's sessions exist
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err := a.ss.Delete(session.ID); err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, "/account/sessions", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelError,
+			Message: views.AlertMessageGeneric,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, "/account/sessions", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "The session has been logged out.",
+	})
+}
+
+// TwoFactorSetup is the data the two factor page needs while
+// the user is enrolling their authenticator app
+type TwoFactorSetup struct {
+	Enabled bool
+	Secret  string
+	// URI is trusted as an otpauth:// link, which html/template
+	// would otherwise replace as an unsafe URL. totp.URI escapes
+	// everything that goes into it.
+	URI template.URL
+}
+
+// TwoFactor shows whether two factor authentication is on
+// and, if it isn't, the secret to add to an authenticator app.
+//
+// GET /account/2fa
+func (a *Account) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	a.renderTwoFactor(w, r, user, vd)
+}
+
+func (a *Account) renderTwoFactor(w http.ResponseWriter, r *http.Request, user *models.User, vd views.Data) {
+	setup := TwoFactorSetup{
+		Enabled: user.TwoFactorEnabled(),
+	}
+	if !setup.Enabled {
+		uri, err := a.us.BeginTOTP(user)
+		if err != nil {
+			log.Println(err)
+			vd.SetAlert(err)
+		}
+		setup.Secret = user.TOTPSecret
+		setup.URI = template.URL(uri)
+	}
+	vd.Yield = setup
+	a.TwoFactorView.Render(w, r, vd)
+}
+
+// EnableTwoFactor confirms the code from the user's app and
+// turns on two factor authentication, showing the recovery
+// codes exactly once.
+//
+// POST /account/2fa
+func (a *Account) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorForm
+	user := context.User(r.Context())
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.renderTwoFactor(w, r, user, vd)
+		return
+	}
+	codes, err := a.us.EnableTOTP(user, form.Code)
+	if err != nil {
+		vd.SetAlert(err)
+		a.renderTwoFactor(w, r, user, vd)
+		return
+	}
+	vd.AlertSuccess("Two-factor authentication is now enabled.")
+	vd.Yield = codes
+	a.RecoveryCodesView.Render(w, r, vd)
+}
+
+type PasswordConfirmForm struct {
+	Password string `schema:"password"`
+}
+
+// DisableTwoFactor turns two factor authentication off after
+// the user confirms their password.
+//
+// POST /account/2fa/disable
+func (a *Account) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form PasswordConfirmForm
+	user := context.User(r.Context())
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.renderTwoFactor(w, r, user, vd)
+		return
+	}
+	if _, err := a.us.Authenticate(user.Email, form.Password); err != nil {
+		vd.SetAlert(err)
+		a.renderTwoFactor(w, r, user, vd)
+		return
+	}
+	if err := a.us.DisableTOTP(user); err != nil {
+		vd.SetAlert(err)
+		a.renderTwoFactor(w, r, user, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/account/2fa", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Two-factor authentication has been turned off.",
+	})
+}
+
+type DeleteAccountForm struct {
+	Password string `schema:"password"`
+	Confirm  string `schema:"confirm"`
+}
+
+// deleteConfirmation has to be typed in to delete an account,
+// so it can't happen by accident
+const deleteConfirmation = "DELETE"
+
+func deletionGraceDays() int {
+	return int(models.AccountDeletionGrace.Hours() / 24)
+}
+
+// ConfirmDelete asks the user to confirm they want their
+// account deleted
+//
+// GET /account/delete
+func (a *Account) ConfirmDelete(w http.ResponseWriter, r *http.Request) {
+	a.DeleteView.Render(w, r, deletionGraceDays())
+}
+
+// Delete schedules the current user's account for deletion
+// and logs them out everywhere. They can restore the account
+// by logging back in before the grace period is over.
+//
+// POST /account/delete
+func (a *Account) Delete(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form DeleteAccountForm
+	vd.Yield = deletionGraceDays()
+	user := context.User(r.Context())
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.DeleteView.Render(w, r, vd)
+		return
+	}
+	if form.Confirm != deleteConfirmation {
+		vd.AlertError("Please type " + deleteConfirmation + " to confirm.")
+		a.DeleteView.Render(w, r, vd)
+		return
+	}
+	user, err := a.us.Authenticate(user.Email, form.Password)
+	if err != nil {
+		vd.SetAlert(err)
+		a.DeleteView.Render(w, r, vd)
+		return
+	}
+	if err := a.us.ScheduleDeletion(user); err != nil {
+		vd.SetAlert(err)
+		a.DeleteView.Render(w, r, vd)
+		return
+	}
+	if err := a.ss.DeleteByUserID(user.ID); err != nil {
+		log.Println(err)
+	}
+	a.cookies.Clear(w, cookie.Session)
+	views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelInfo,
+		Message: "Your account will be deleted on " + user.PurgeAt().Format("January 2, 2006") + ". Log in before then if you change your mind.",
+	})
+}
+
+// ConfirmRestore is shown to users who log in while their
+// account is scheduled for deletion
+//
+// GET /account/restore
+func (a *Account) ConfirmRestore(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if !user.DeletionScheduled() {
+		http.Redirect(w, r, "/account", http.StatusFound)
+		return
+	}
+	a.RestoreView.Render(w, r, user.PurgeAt())
+}
+
+// Restore cancels the scheduled deletion of the current user
+//
+// POST /account/restore
+func (a *Account) Restore(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if err := a.us.CancelDeletion(user); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		vd.Yield = user.PurgeAt()
+		a.RestoreView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Welcome back! Your account has been restored.",
+	})
+}
+
+// TokensPage is the data the personal access tokens page needs.
+// NewToken is only set straight after a token is created, as
+// that is the only time the raw token is known.
+type TokensPage struct {
+	Tokens   []models.APIToken
+	Scopes   []string
+	NewToken string
+	Form     TokenForm
+}
+
+type TokenForm struct {
+	Name   string   `schema:"name"`
+	Scopes []string `schema:"scopes"`
+	// ExpiresIn is the number of days the token lasts, or 0 if
+	// it never expires
+	ExpiresIn int `schema:"expires_in"`
+}
+
+// Tokens lists the current user's personal access tokens and
+// lets them create new ones
+//
+// GET /account/tokens
+func (a *Account) Tokens(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	a.renderTokens(w, r, vd, TokensPage{
+		Form: TokenForm{ExpiresIn: 30},
+	})
+}
+
+func (a *Account) renderTokens(w http.ResponseWriter, r *http.Request, vd views.Data, page TokensPage) {
+	user := context.User(r.Context())
+	tokens, err := a.ts.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+	}
+	page.Tokens = tokens
+	page.Scopes = models.APIScopes
+	vd.Yield = page
+	a.TokensView.Render(w, r, vd)
+}
+
+// CreateToken creates a personal access token and shows it
+// to the user, once
+//
+// POST /account/tokens
+func (a *Account) CreateToken(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TokenForm
+	user := context.User(r.Context())
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.renderTokens(w, r, vd, TokensPage{Form: form})
+		return
+	}
+	if session := context.Session(r.Context()); session != nil && session.Impersonated() {
+		// a token would outlive the impersonation
+		http.Error(w, "Access tokens can't be created while acting as another user", http.StatusForbidden)
+		return
+	}
+	token := models.APIToken{
+		UserID: user.ID,
+		Name:   form.Name,
+		Scopes: strings.Join(form.Scopes, " "),
+	}
+	if form.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, form.ExpiresIn)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := a.ts.Create(&token); err != nil {
+		vd.SetAlert(err)
+		a.renderTokens(w, r, vd, TokensPage{Form: form})
+		return
+	}
+	vd.AlertSuccess("Your token has been created. Copy it now, you won't be able to see it again.")
+	a.renderTokens(w, r, vd, TokensPage{
+		NewToken: token.Token,
+		Form:     TokenForm{ExpiresIn: 30},
+	})
+}
+
+// RevokeToken deletes one of the current user's personal
+// access tokens, so scripts using it stop working
+//
+// POST /account/tokens/:id/revoke
+func (a *Account) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusNotFound)
+		return
+	}
+	token, err := a.ts.ByID(uint(id))
+	if err != nil || !policy.CanRevokeAPIToken(user, token) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err := a.ts.Delete(token.ID); err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, "/account/tokens", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelError,
+			Message: views.AlertMessageGeneric,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, "/account/tokens", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "The token has been revoked.",
+	})
+}
+
+// InvitationsPage is the data the invitations page needs.
+// NewLink is only set straight after an invitation is created,
+// as that is the only time its code is known.
+type InvitationsPage struct {
+	Invitations []models.Invitation
+	MaxUses     int
+	NewLink     string
+	Form        InvitationForm
+}
+
+type InvitationForm struct {
+	// Email is optional, and limits the invitation to a single
+	// address
+	Email   string `schema:"email"`
+	MaxUses int    `schema:"max_uses"`
+	// ExpiresIn is the number of days the invitation lasts
+	ExpiresIn int `schema:"expires_in"`
+}
+
+func newInvitationForm() InvitationForm {
+	return InvitationForm{MaxUses: 1, ExpiresIn: 7}
+}
+
+// Invitations lists the invitations the current user has sent
+// and lets them invite more people
+//
+// GET /account/invitations
+func (a *Account) Invitations(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	a.renderInvitations(w, r, vd, InvitationsPage{Form: newInvitationForm()})
+}
+
+func (a *Account) renderInvitations(w http.ResponseWriter, r *http.Request, vd views.Data, page InvitationsPage) {
+	user := context.User(r.Context())
+	invitations, err := a.is.ByCreator(user.ID)
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+	}
+	page.Invitations = invitations
+	page.MaxUses = policy.MaxInvitationUses(user)
+	vd.Yield = page
+	a.InvitationsView.Render(w, r, vd)
+}
+
+// CreateInvitation creates an invitation to sign up. If it is
+// for a particular address it is emailed there, and either way
+// the link is shown to the user, once.
+//
+// POST /account/invitations
+func (a *Account) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form InvitationForm
+	user := context.User(r.Context())
+	if !policy.CanInvite(user) {
+		http.Error(w, "You need to verify your email address before inviting people", http.StatusForbidden)
+		return
+	}
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		a.renderInvitations(w, r, vd, InvitationsPage{Form: form})
+		return
+	}
+	if form.MaxUses > policy.MaxInvitationUses(user) {
+		vd.SetAlert(models.ErrMaxUsesInvalid)
+		a.renderInvitations(w, r, vd, InvitationsPage{Form: form})
+		return
+	}
+	inv := models.Invitation{
+		CreatedByID: user.ID,
+		Email:       form.Email,
+		MaxUses:     form.MaxUses,
+		ExpiresAt:   time.Now().AddDate(0, 0, form.ExpiresIn),
+	}
+	if err := a.is.Create(&inv); err != nil {
+		vd.SetAlert(err)
+		a.renderInvitations(w, r, vd, InvitationsPage{Form: form})
+		return
+	}
+	vd.AlertSuccess("Your invitation has been created. Copy the link now, you won't be able to see it again.")
+	if inv.Email != "" {
+		if err := a.emailer.Invite(inv.Email, user.Name, inv.Code, inv.ExpiresAt); err != nil {
+			log.Println(err)
+			vd.Alert = &views.Alert{
+				Level:   views.AlertLevelWarning,
+				Message: "Your invitation has been created, but we couldn't email it. Copy the link and send it yourself.",
+			}
+		} else {
+			vd.AlertSuccess("Your invitation has been emailed to " + inv.Email + ". You can also share the link below yourself.")
+		}
+	}
+	a.renderInvitations(w, r, vd, InvitationsPage{
+		NewLink: a.emailer.InviteURL(inv.Code),
+		Form:    newInvitationForm(),
+	})
+}
+
+// RevokeInvitation deletes one of the current user's
+// invitations, so nobody else can sign up with it
+//
+// POST /account/invitations/:id/revoke
+func (a *Account) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusNotFound)
+		return
+	}
+	inv, err := a.is.ByID(uint(id))
+	if err != nil || !policy.CanRevokeInvitation(user, inv) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err := a.is.Delete(inv.ID); err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, "/account/invitations", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelError,
+			Message: views.AlertMessageGeneric,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, "/account/invitations", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "The invitation has been revoked.",
+	})
+}
@@ -0,0 +1,458 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"lenslocked.com/context"
+	"lenslocked.com/cookie"
+	"lenslocked.com/email"
+	"lenslocked.com/models"
+	"lenslocked.com/netutil"
+	"lenslocked.com/policy"
+	"lenslocked.com/views"
+)
+
+// adminPageSize is how many rows the admin lists show at once
+const adminPageSize = 50
+
+// NewAdmin is used to create a new Admin controller.
+// This function will panic if the templates are not
+// parsed correctly, and should only be used during initial setup
+func NewAdmin(us models.UserService, gs models.GalleryService, ss models.SessionService, audit models.AuditService, cookies *cookie.Manager, emailer *email.Client) *Admin {
+	return &Admin{
+		UsersView:     views.NewView("bootstrap", "admin/users"),
+		UserView:      views.NewView("bootstrap", "admin/user"),
+		GalleriesView: views.NewView("bootstrap", "admin/galleries"),
+		AuditView:     views.NewView("bootstrap", "admin/audit"),
+		us:            us,
+		gs:            gs,
+		ss:            ss,
+		audit:         audit,
+		cookies:       cookies,
+		emailer:       emailer,
+	}
+}
+
+// Admin holds the pages staff use to manage other people's
+// accounts and content. Every change made through it is
+// written to the audit log.
+type Admin struct {
+	UsersView     *views.View
+	UserView      *views.View
+	GalleriesView *views.View
+	AuditView     *views.View
+	us            models.UserService
+	gs            models.GalleryService
+	ss            models.SessionService
+	audit         models.AuditService
+	cookies       *cookie.Manager
+	emailer       *email.Client
+}
+
+// SearchForm is used by the admin lists to filter and page
+// through their rows
+type SearchForm struct {
+	Query string `schema:"q"`
+	Page  int    `schema:"page"`
+}
+
+func (sf *SearchForm) offset() int {
+	if sf.Page < 1 {
+		sf.Page = 1
+	}
+	return (sf.Page - 1) * adminPageSize
+}
+
+// AdminList is the data rendered by the paged admin lists
+type AdminList struct {
+	Query    string
+	Page     int
+	PrevPage int
+	NextPage int
+	Rows     interface{}
+}
+
+func newAdminList(form SearchForm, n int, rows interface{}) *AdminList {
+	list := AdminList{
+		Query: form.Query,
+		Page:  form.Page,
+		Rows:  rows,
+	}
+	if form.Page > 1 {
+		list.PrevPage = form.Page - 1
+	}
+	if n == adminPageSize {
+		list.NextPage = form.Page + 1
+	}
+	return &list
+}
+
+// Index sends staff to the first page of the admin area
+//
+// GET /admin
+func (a *Admin) Index(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/admin/users", http.StatusFound)
+}
+
+// Users lists and searches every account
+//
+// GET /admin/users
+func (a *Admin) Users(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form SearchForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	users, err := a.us.Search(form.Query, adminPageSize, form.offset())
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+	}
+	vd.Yield = newAdminList(form, len(users), users)
+	a.UsersView.Render(w, r, vd)
+}
+
+// AdminUser is the data the admin page for a single user needs
+type AdminUser struct {
+	User     *models.User
+	Sessions []models.Session
+	Audit    []models.AuditEntry
+}
+
+// User shows a single account along with its sessions and
+// everything staff have done to it
+//
+// GET /admin/users/:id
+func (a *Admin) User(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	data := AdminUser{User: user}
+	if data.Sessions, err = a.ss.ByUserID(user.ID); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+	}
+	if data.Audit, err = a.audit.ByTarget("user", user.ID); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+	}
+	vd.Yield = data
+	a.UserView.Render(w, r, vd)
+}
+
+type DisableForm struct {
+	Reason string `schema:"reason"`
+}
+
+// DisableUser stops the user from logging in and logs them out
+//
+// POST /admin/users/:id/disable
+func (a *Admin) DisableUser(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	var form DisableForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+	}
+	if !a.canManage(w, user) {
+		return
+	}
+	if err := a.us.Disable(user, form.Reason); err != nil {
+		a.userError(w, r, user, err)
+		return
+	}
+	a.record(r, models.AuditUserDisabled, "user", user.ID, form.Reason)
+	a.userSuccess(w, r, user, "The account has been disabled.")
+}
+
+// EnableUser lets a disabled user log in again
+//
+// POST /admin/users/:id/enable
+func (a *Admin) EnableUser(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, user) {
+		return
+	}
+	if err := a.us.Enable(user); err != nil {
+		a.userError(w, r, user, err)
+		return
+	}
+	a.record(r, models.AuditUserEnabled, "user", user.ID, "")
+	a.userSuccess(w, r, user, "The account has been enabled.")
+}
+
+// ResetPassword throws away the user's password, logs them
+// out everywhere and emails them a link to choose a new one
+//
+// POST /admin/users/:id/reset-password
+func (a *Admin) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, user) {
+		return
+	}
+	token, err := a.us.ForceReset(user)
+	if err != nil {
+		a.userError(w, r, user, err)
+		return
+	}
+	if err := a.ss.DeleteByUserID(user.ID); err != nil {
+		log.Println(err)
+	}
+	if err := a.emailer.ResetPw(user.Email, token); err != nil {
+		log.Println(err)
+	}
+	a.record(r, models.AuditUserPasswordReset, "user", user.ID, "")
+	a.userSuccess(w, r, user, "The password has been reset and a link to choose a new one has been emailed to the user.")
+}
+
+// DeleteUser schedules the account for deletion, exactly as if
+// the user had asked for it themselves, and logs them out
+//
+// POST /admin/users/:id/delete
+func (a *Admin) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, user) {
+		return
+	}
+	if err := a.us.ScheduleDeletion(user); err != nil {
+		a.userError(w, r, user, err)
+		return
+	}
+	if err := a.ss.DeleteByUserID(user.ID); err != nil {
+		log.Println(err)
+	}
+	a.record(r, models.AuditUserDeleted, "user", user.ID, "")
+	a.userSuccess(w, r, user, fmt.Sprintf("The account will be permanently deleted on %s.",
+		user.PurgeAt().Format("Jan 2, 2006")))
+}
+
+// Impersonate logs the admin in as the user for a limited
+// time. The admin's own session is kept in a cookie so they
+// can switch back with StopImpersonating.
+//
+// POST /admin/users/:id/impersonate
+func (a *Admin) Impersonate(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, user) {
+		return
+	}
+	admin := context.User(r.Context())
+	adminCookie, err := r.Cookie(cookie.Session)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	session := models.Session{
+		UserID:         user.ID,
+		UserAgent:      r.UserAgent(),
+		IP:             netutil.ClientIP(r),
+		ExpiresAt:      time.Now().Add(models.ImpersonationDuration),
+		ImpersonatorID: admin.ID,
+	}
+	if err := a.ss.Create(&session); err != nil {
+		a.userError(w, r, user, err)
+		return
+	}
+	a.cookies.Set(w, cookie.Impersonator, adminCookie.Value, session.ExpiresAt)
+	a.cookies.SetSession(w, &session)
+	a.record(r, models.AuditImpersonationStart, "user", user.ID, "")
+	views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelInfo,
+		Message: fmt.Sprintf("You are now acting as %s.", user.Email),
+	})
+}
+
+// StopImpersonating ends an impersonation started with
+// Impersonate and logs the admin back in as themselves. It is
+// reached as the impersonated user, so it can't sit behind the
+// admin middleware.
+//
+// POST /impersonate/stop
+func (a *Admin) StopImpersonating(w http.ResponseWriter, r *http.Request) {
+	session := context.Session(r.Context())
+	if session == nil || !session.Impersonated() {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	if err := a.ss.Delete(session.ID); err != nil {
+		log.Println(err)
+	}
+	a.cookies.Clear(w, cookie.Impersonator)
+	impersonator, err := r.Cookie(cookie.Impersonator)
+	if err != nil {
+		a.cookies.Clear(w, cookie.Session)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	adminSession, err := a.ss.ByRemember(impersonator.Value)
+	if err != nil || adminSession.UserID != session.ImpersonatorID {
+		a.cookies.Clear(w, cookie.Session)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	adminSession.Token = impersonator.Value
+	a.cookies.SetSession(w, adminSession)
+	entry := models.AuditEntry{
+		ActorID:    session.ImpersonatorID,
+		Action:     models.AuditImpersonationFinish,
+		TargetType: "user",
+		TargetID:   session.UserID,
+		IP:         netutil.ClientIP(r),
+	}
+	if err := a.audit.Create(&entry); err != nil {
+		log.Println(err)
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", session.UserID), http.StatusFound)
+}
+
+// Galleries lists and searches every gallery
+//
+// GET /admin/galleries
+func (a *Admin) Galleries(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form SearchForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	galleries, err := a.gs.Search(form.Query, adminPageSize, form.offset())
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+	}
+	vd.Yield = newAdminList(form, len(galleries), galleries)
+	a.GalleriesView.Render(w, r, vd)
+}
+
+// DeleteGallery removes a gallery that breaks the rules
+//
+// POST /admin/galleries/:id/delete
+func (a *Admin) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return
+	}
+	gallery, err := a.gs.ByID(uint(id))
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if !policy.CanDeleteGallery(context.User(r.Context()), gallery) {
+		http.Error(w, "You are not allowed to delete this gallery", http.StatusForbidden)
+		return
+	}
+	if err := a.gs.Delete(gallery.ID); err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, "/admin/galleries", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelError,
+			Message: views.AlertMessageGeneric,
+		})
+		return
+	}
+	a.record(r, models.AuditGalleryDeleted, "gallery", gallery.ID, gallery.Title)
+	views.RedirectAlert(w, r, "/admin/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "The gallery has been deleted.",
+	})
+}
+
+// Audit lists everything staff have done, newest first
+//
+// GET /admin/audit
+func (a *Admin) Audit(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form SearchForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	entries, err := a.audit.Recent(adminPageSize, form.offset())
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+	}
+	vd.Yield = newAdminList(form, len(entries), entries)
+	a.AuditView.Render(w, r, vd)
+}
+
+// userByID looks up the user in the URL. If it can't be found
+// a 404 has already been written when the error is returned.
+func (a *Admin) userByID(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusNotFound)
+		return nil, err
+	}
+	user, err := a.us.ByID(uint(id))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, err
+	}
+	return user, nil
+}
+
+// canManage stops admins from using the admin area against
+// other admins or themselves, writing a 403 if they try
+func (a *Admin) canManage(w http.ResponseWriter, user *models.User) bool {
+	if user.IsAdmin() {
+		http.Error(w, "Admin accounts can't be changed from the admin area", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// record writes an entry to the audit log for an action the
+// current user took. Failing to record it is only logged, as
+// the action itself has already happened.
+func (a *Admin) record(r *http.Request, action, targetType string, targetID uint, details string) {
+	entry := models.AuditEntry{
+		ActorID:    context.User(r.Context()).ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+		IP:         netutil.ClientIP(r),
+	}
+	if err := a.audit.Create(&entry); err != nil {
+		log.Println(err)
+	}
+}
+
+func (a *Admin) userSuccess(w http.ResponseWriter, r *http.Request, user *models.User, msg string) {
+	views.RedirectAlert(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: msg,
+	})
+}
+
+func (a *Admin) userError(w http.ResponseWriter, r *http.Request, user *models.User, err error) {
+	log.Println(err)
+	alert := views.Alert{
+		Level:   views.AlertLevelError,
+		Message: views.AlertMessageGeneric,
+	}
+	if pErr, ok := err.(views.PublicError); ok {
+		alert.Message = pErr.Public()
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusFound, alert)
+}
@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/csrf"
+	"lenslocked.com/views"
+)
+
+// NewErrors is used to create a new Errors controller.
+// This function will panic if the templates are not
+// parsed correctly, and should only be used during initial setup
+func NewErrors() *Errors {
+	return &Errors{
+		CSRFView: views.NewView("bootstrap", "errors/csrf"),
+	}
+}
+
+// Errors renders the pages shown when a request is rejected
+// before it reaches the handler it was meant for
+type Errors struct {
+	CSRFView *views.View
+}
+
+// CSRF is shown when a form is submitted without a valid
+// CSRF token
+func (e *Errors) CSRF(w http.ResponseWriter, r *http.Request) {
+	log.Println("csrf:", csrf.FailureReason(r))
+	// the header has to be set before the status is written,
+	// Render is too late
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusForbidden)
+	e.CSRFView.Render(w, r, nil)
+}
@@ -3,31 +3,88 @@ package controllers
 import (
 	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/gorilla/mux"
+	"lenslocked.com/context"
 	"lenslocked.com/models"
+	"lenslocked.com/policy"
 	"lenslocked.com/views"
 )
 
 // NewGalleries is used to create a new Galleries controller.
 // This function will panic if the templates are not
 // parsed correctly, and should only be used during initial setup
-func NewGalleries(gs models.GalleryService) *Galleries {
+func NewGalleries(gs models.GalleryService, is models.ImageService) *Galleries {
 	return &Galleries{
-		New: views.NewView("bootstrap", "galleries/new"),
-		gs:  gs,
+		New:       views.NewView("bootstrap", "galleries/new"),
+		ShowView:  views.NewView("bootstrap", "galleries/show"),
+		EditView:  views.NewView("bootstrap", "galleries/edit"),
+		IndexView: views.NewView("bootstrap", "galleries/index"),
+		gs:        gs,
+		is:        is,
 	}
 }
 
 type Galleries struct {
-	New *views.View
-	gs  models.GalleryService
+	New       *views.View
+	ShowView  *views.View
+	EditView  *views.View
+	IndexView *views.View
+	gs        models.GalleryService
+	is        models.ImageService
 }
 
 type GalleryForm struct {
 	Title string `schema:"title"`
 }
 
+// Index lists the galleries of the current user
+//
+// GET /galleries
+func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	galleries, err := g.gs.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+	}
+	vd.Yield = galleries
+	g.IndexView.Render(w, r, vd)
+}
+
+// Show is the public page of a gallery
+//
+// GET /galleries/:id
+func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	if !policy.CanViewGallery(context.User(r.Context()), gallery) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	g.loadImages(&vd, gallery)
+	vd.Yield = GalleryPage{
+		Gallery: gallery,
+		Images:  views.NewImages(gallery.Images),
+	}
+	g.ShowView.Render(w, r, vd)
+}
+
+// GalleryPage is the data the public page of a gallery needs,
+// with its images ready to be shown at the right size
+type GalleryPage struct {
+	Gallery *models.Gallery
+	Images  []views.Image
+}
+
 // POST /galleries
 func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	var form GalleryForm
@@ -35,16 +92,233 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	if err := parseForm(r, &form); err != nil {
 		log.Println(err)
 		vd.SetAlert(err)
-		g.New.Render(w, vd)
+		g.New.Render(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	if !policy.CanCreateGallery(user) {
+		http.Error(w, "You are not allowed to create galleries", http.StatusForbidden)
 		return
 	}
 	gallery := models.Gallery{
-		Title: form.Title,
+		Title:  form.Title,
+		UserID: user.ID,
 	}
 	if err := g.gs.Create(&gallery); err != nil {
 		vd.SetAlert(err)
-		g.New.Render(w, vd)
+		g.New.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, editGalleryPath(&gallery), http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Your gallery has been created.",
+	})
+}
+
+// Edit shows the form the owner of a gallery uses to change it
+//
+// GET /galleries/:id/edit
+func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil || !g.canEdit(w, r, gallery) {
+		return
+	}
+	var vd views.Data
+	g.loadImages(&vd, gallery)
+	vd.Yield = gallery
+	g.EditView.Render(w, r, vd)
+}
+
+// Update saves the changes made on the edit form
+//
+// POST /galleries/:id/update
+func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil || !g.canEdit(w, r, gallery) {
+		return
+	}
+	var vd views.Data
+	g.loadImages(&vd, gallery)
+	vd.Yield = gallery
+	var form GalleryForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	gallery.Title = form.Title
+	if err := g.gs.Update(gallery); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	vd.AlertSuccess("Your gallery has been updated.")
+	g.EditView.Render(w, r, vd)
+}
+
+// Delete removes a gallery for good
+//
+// POST /galleries/:id/delete
+func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	if !policy.CanDeleteGallery(context.User(r.Context()), gallery) {
+		http.Error(w, "You are not allowed to delete this gallery", http.StatusForbidden)
 		return
 	}
-	fmt.Fprintln(w, gallery)
+	if err := g.gs.Delete(gallery.ID); err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, editGalleryPath(gallery), http.StatusFound, views.Alert{
+			Level:   views.AlertLevelError,
+			Message: views.AlertMessageGeneric,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, "/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Your gallery has been deleted.",
+	})
+}
+
+// maxMultipartMemory is how much of an upload is kept in
+// memory; the rest is buffered in temporary files
+const maxMultipartMemory = 10 << 20
+
+// ImageUpload adds the images picked on the edit page to the
+// gallery. Images are handled one at a time, so one bad file
+// doesn't stop the rest from being uploaded.
+//
+// POST /galleries/:id/images
+func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil || !g.canEdit(w, r, gallery) {
+		return
+	}
+	if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, editGalleryPath(gallery), http.StatusFound, views.Alert{
+			Level:   views.AlertLevelError,
+			Message: "The upload failed. Please try again with fewer or smaller images.",
+		})
+		return
+	}
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		views.RedirectAlert(w, r, editGalleryPath(gallery), http.StatusFound, views.Alert{
+			Level:   views.AlertLevelWarning,
+			Message: "Please pick at least one image to upload.",
+		})
+		return
+	}
+	var failed []string
+	for _, fh := range files {
+		if err := g.uploadImage(gallery, fh); err != nil {
+			log.Println(err)
+			failed = append(failed, fmt.Sprintf("%s (%s)", fh.Filename, views.PublicMessage(err)))
+		}
+	}
+	alert := views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: fmt.Sprintf("%d image(s) uploaded.", len(files)),
+	}
+	if len(failed) > 0 {
+		alert = views.Alert{
+			Level: views.AlertLevelWarning,
+			Message: fmt.Sprintf("%d of %d image(s) uploaded. These failed: %s",
+				len(files)-len(failed), len(files), strings.Join(failed, ", ")),
+		}
+	}
+	views.RedirectAlert(w, r, editGalleryPath(gallery), http.StatusFound, alert)
+}
+
+func (g *Galleries) uploadImage(gallery *models.Gallery, fh *multipart.FileHeader) error {
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = g.is.Create(gallery.ID, f, fh.Filename)
+	return err
+}
+
+// ImageDelete removes one image from a gallery
+//
+// POST /galleries/:id/images/:filename/delete
+func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil || !g.canEdit(w, r, gallery) {
+		return
+	}
+	image := models.Image{
+		GalleryID: gallery.ID,
+		Filename:  mux.Vars(r)["filename"],
+	}
+	if err := g.is.Delete(&image); err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		views.RedirectAlert(w, r, editGalleryPath(gallery), http.StatusFound, views.Alert{
+			Level:   views.AlertLevelError,
+			Message: views.AlertMessageGeneric,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, editGalleryPath(gallery), http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "The image has been deleted.",
+	})
+}
+
+// loadImages fills in the gallery's images, showing an alert
+// if they can't be listed
+func (g *Galleries) loadImages(vd *views.Data, gallery *models.Gallery) {
+	images, err := g.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		return
+	}
+	gallery.Images = images
+}
+
+// galleryByID looks up the gallery in the URL. If it can't be
+// found an error page has already been written when the error
+// is returned.
+func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := g.gs.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, views.AlertMessageGeneric, http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// canEdit checks the current user can change gallery, and
+// writes a 403 if they can't
+func (g *Galleries) canEdit(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) bool {
+	if !policy.CanEditGallery(context.User(r.Context()), gallery) {
+		http.Error(w, "You are not allowed to edit this gallery", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func editGalleryPath(gallery *models.Gallery) string {
+	return fmt.Sprintf("/galleries/%d/edit", gallery.ID)
 }
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/gorilla/schema"
 )
@@ -10,9 +11,20 @@ func parseForm(r *http.Request, dst interface{}) error {
 	if err := r.ParseForm(); err != nil {
 		return err
 	}
+	return parseValues(r.PostForm, dst)
+}
+
+func parseURLParams(r *http.Request, dst interface{}) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	return parseValues(r.Form, dst)
+}
 
+func parseValues(values url.Values, dst interface{}) error {
 	dec := schema.NewDecoder()
-	if err := dec.Decode(dst, r.PostForm); err != nil {
+	dec.IgnoreUnknownKeys(true)
+	if err := dec.Decode(dst, values); err != nil {
 		return err
 	}
 	return nil
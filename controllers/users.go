@@ -1,45 +1,134 @@
 package controllers
 
 import (
-	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"lenslocked.com/context"
+	"lenslocked.com/cookie"
+	"lenslocked.com/email"
 	"lenslocked.com/models"
-	"lenslocked.com/rand"
+	"lenslocked.com/netutil"
 	"lenslocked.com/views"
 )
 
 type Users struct {
-	NewView   *views.View
-	LoginView *views.View
-	us        models.UserService
+	NewView       *views.View
+	LoginView     *views.View
+	MagicLinkView *views.View
+	ForgotPwView  *views.View
+	ResetPwView   *views.View
+	TwoFactorView *views.View
+	us            models.UserService
+	ss            models.SessionService
+	is            models.InvitationService
+	cookies       *cookie.Manager
+	emailer       *email.Client
+	methods       LoginMethods
+	// inviteOnly turns off signing up without an invitation
+	inviteOnly bool
+}
+
+// LoginMethods picks the ways users can log in, and so which
+// forms appear on the login page
+type LoginMethods struct {
+	Password  bool
+	MagicLink bool
 }
 
 // NewUsers is used to create a new USERS controller.
 // This function will panic if the templates are not
 // parsed correctly, and should only be used during
 // initial setup
-func NewUsers(us models.UserService) *Users {
+func NewUsers(us models.UserService, ss models.SessionService, is models.InvitationService, cookies *cookie.Manager, emailer *email.Client, methods LoginMethods, inviteOnly bool) *Users {
 	return &Users{
-		NewView:   views.NewView("bootstrap", "users/new"),
-		LoginView: views.NewView("bootstrap", "users/login"),
-		us:        us,
+		NewView:       views.NewView("bootstrap", "users/new"),
+		LoginView:     views.NewView("bootstrap", "users/login"),
+		MagicLinkView: views.NewView("bootstrap", "users/magic_link"),
+		ForgotPwView:  views.NewView("bootstrap", "users/forgot_pw"),
+		ResetPwView:   views.NewView("bootstrap", "users/reset_pw"),
+		TwoFactorView: views.NewView("bootstrap", "users/two_factor"),
+		us:            us,
+		ss:            ss,
+		is:            is,
+		cookies:       cookies,
+		emailer:       emailer,
+		methods:       methods,
+		inviteOnly:    inviteOnly,
 	}
 }
 
 // New is to render the form where a user can create
-// a new user account.
+// a new user account. People following an invitation
+// link have its code in the invite query parameter.
 //
 // GET /signup
 func (u *Users) New(w http.ResponseWriter, r *http.Request) {
-	u.NewView.Render(w, nil)
+	var vd views.Data
+	var form SignupForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	if _, err := u.signupInvitation(form.Invite); err != nil && form.Invite != "" {
+		vd.SetAlert(err)
+	}
+	u.renderSignup(w, r, vd, form)
 }
 
 type SignupForm struct {
 	Name     string `schema:"name"`
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
+	Invite   string `schema:"invite"`
+}
+
+// SignupPage is the data the signup form needs. EmailLocked is
+// set when the invitation being used was sent to one address,
+// which is then the only one that can be used.
+type SignupPage struct {
+	Form        SignupForm
+	InviteOnly  bool
+	EmailLocked bool
+}
+
+func (u *Users) renderSignup(w http.ResponseWriter, r *http.Request, vd views.Data, form SignupForm) {
+	page := SignupPage{Form: form, InviteOnly: u.inviteOnly}
+	if inv, err := u.signupInvitation(form.Invite); err == nil && inv != nil && inv.Email != "" {
+		page.Form.Email = inv.Email
+		page.EmailLocked = true
+	}
+	page.Form.Password = ""
+	vd.Yield = page
+	u.NewView.Render(w, r, vd)
+}
+
+// signupInvitation looks up the invitation someone is signing
+// up with. Having no usable invitation is only an error when
+// registration is invite only; otherwise nil is returned and
+// the invitation is ignored.
+func (u *Users) signupInvitation(code string) (*models.Invitation, error) {
+	if code == "" {
+		if u.inviteOnly {
+			return nil, models.ErrInvitationRequired
+		}
+		return nil, nil
+	}
+	inv, err := u.is.ByCode(code)
+	switch {
+	case err == models.ErrNotFound:
+		err = models.ErrInvitationInvalid
+	case err == nil && !inv.Usable():
+		err = models.ErrInvitationInvalid
+	}
+	if err != nil {
+		if u.inviteOnly {
+			return nil, err
+		}
+		log.Println(err)
+		return nil, nil
+	}
+	return inv, nil
 }
 
 // Create is use to process the signup form when a user submits it
@@ -52,30 +141,76 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 	if err := parseForm(r, &form); err != nil {
 		log.Println(err)
 		vd.SetAlert(err)
-		u.NewView.Render(w, vd)
+		u.renderSignup(w, r, vd, form)
+		return
+	}
+	inv, err := u.signupInvitation(form.Invite)
+	if err != nil {
+		vd.SetAlert(err)
+		u.renderSignup(w, r, vd, form)
 		return
 	}
+	if inv != nil {
+		// the invitation is taken before the account is created,
+		// so two people can't both sign up with its last use
+		if err := u.is.Redeem(inv); err != nil {
+			if u.inviteOnly {
+				vd.SetAlert(err)
+				u.renderSignup(w, r, vd, form)
+				return
+			}
+			log.Println(err)
+			inv = nil
+		}
+	}
+	if inv != nil && inv.Email != "" {
+		// the email field is read only, but we can't trust that
+		form.Email = inv.Email
+	}
 	user := models.User{
 		Name:     form.Name,
 		Email:    form.Email,
 		Password: form.Password,
 	}
 	if err := u.us.Create(&user); err != nil {
+		// a typo in the form shouldn't use up the invitation
+		if inv != nil {
+			if rerr := u.is.Release(inv); rerr != nil {
+				log.Println(rerr)
+			}
+		}
 		vd.SetAlert(err)
-		u.NewView.Render(w, vd)
+		u.renderSignup(w, r, vd, form)
 		return
 	}
-	err := u.signIn(w, &user)
+	sendVerification(u.us, u.emailer, &user)
+	err = u.signIn(w, r, &user, false)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
-	http.Redirect(w, r, "/cookie-test", http.StatusFound)
+	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
 type LoginForm struct {
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
+	// Remember keeps the user logged in after the browser
+	// is closed
+	Remember bool `schema:"remember"`
+}
+
+// LoginPage shows the login forms for the login methods that
+// are turned on
+//
+// GET /login
+func (u *Users) LoginPage(w http.ResponseWriter, r *http.Request) {
+	u.renderLogin(w, r, views.Data{})
+}
+
+func (u *Users) renderLogin(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	vd.Yield = u.methods
+	u.LoginView.Render(w, r, vd)
 }
 
 // Login is used to verify the provided email and password and
@@ -83,16 +218,20 @@ type LoginForm struct {
 //
 // POST /login
 func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
+	if !u.methods.Password {
+		http.NotFound(w, r)
+		return
+	}
 	form := LoginForm{}
 	vd := views.Data{}
 	if err := parseForm(r, &form); err != nil {
 		log.Println(err)
 		vd.SetAlert(err)
-		u.LoginView.Render(w, vd)
+		u.renderLogin(w, r, vd)
 		return
 	}
 
-	user, err := u.us.Authenticate(form.Email, form.Password)
+	user, err := u.us.AuthenticateFrom(netutil.ClientIP(r), form.Email, form.Password)
 
 	if err != nil {
 		switch err {
@@ -101,53 +240,445 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 		default:
 			vd.SetAlert(err)
 		}
-		u.LoginView.Render(w, vd)
+		u.renderLogin(w, r, vd)
 		return
 	}
 
-	err = u.signIn(w, user)
+	if user.TwoFactorEnabled() {
+		u.startTwoFactor(w, r, user, form.Remember)
+		return
+	}
+
+	err = u.signIn(w, r, user, form.Remember)
 
 	if err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, vd)
+		u.renderLogin(w, r, vd)
 		return
 	}
-	http.Redirect(w, r, "/cookie-test", http.StatusFound)
+	http.Redirect(w, r, afterLoginPath(user), http.StatusFound)
 }
 
-// signIn is used ot sign the given user in via cookies
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
-	if user.Remember == "" {
-		token, err := rand.RememberToken()
-		if err != nil {
-			return err
-		}
-		user.Remember = token
-		err = u.us.Update(user)
-		if err != nil {
-			return err
+// afterLoginPath is where users are sent once they have logged
+// in. Users whose account is scheduled for deletion are offered
+// the chance to restore it first.
+func afterLoginPath(user *models.User) string {
+	if user.DeletionScheduled() {
+		return "/account/restore"
+	}
+	return "/galleries"
+}
+
+// startTwoFactor remembers that the user entered the correct
+// password in a short lived cookie, and asks them for the
+// code from their authenticator app. Whether they asked to be
+// remembered is carried through the second form.
+func (u *Users) startTwoFactor(w http.ResponseWriter, r *http.Request, user *models.User, remember bool) {
+	u.cookies.Set(w, cookie.TwoFactor, u.us.TwoFactorChallenge(user), time.Now().Add(5*time.Minute))
+	u.TwoFactorView.Render(w, r, TwoFactorForm{Remember: remember})
+}
+
+type TwoFactorForm struct {
+	Code     string `schema:"code"`
+	Remember bool   `schema:"remember"`
+}
+
+// LoginTwoFactor is the second step of logging in for users
+// with two factor authentication. It checks the code from
+// their authenticator app, or a recovery code, and then
+// signs them in.
+//
+// POST /login/2fa
+func (u *Users) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorForm
+	challenge, err := r.Cookie(cookie.TwoFactor)
+	if err != nil {
+		views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelWarning,
+			Message: "Your login has timed out, please log in again.",
+		})
+		return
+	}
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.us.VerifyTwoFactor(challenge.Value, form.Code)
+	switch err {
+	case nil:
+	case models.ErrTokenInvalid:
+		views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelWarning,
+			Message: "Your login has timed out, please log in again.",
+		})
+		return
+	default:
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+
+	u.cookies.Clear(w, cookie.TwoFactor)
+	if err := u.signIn(w, r, user, form.Remember); err != nil {
+		vd.SetAlert(err)
+		u.renderLogin(w, r, vd)
+		return
+	}
+	http.Redirect(w, r, afterLoginPath(user), http.StatusFound)
+}
+
+type MagicLinkForm struct {
+	Email    string `schema:"email"`
+	Token    string `schema:"token"`
+	Remember bool   `schema:"remember"`
+}
+
+// SendMagicLink emails a single use login link. Like
+// InitiateReset it responds the same way whether or not the
+// address belongs to an account.
+//
+// POST /login/magic
+func (u *Users) SendMagicLink(w http.ResponseWriter, r *http.Request) {
+	if !u.methods.MagicLink {
+		http.NotFound(w, r)
+		return
+	}
+	var vd views.Data
+	var form MagicLinkForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		u.renderLogin(w, r, vd)
+		return
+	}
+
+	user, token, err := u.us.InitiateMagicLogin(form.Email)
+	switch err {
+	case nil:
+		if err := u.emailer.MagicLink(user.Email, token); err != nil {
+			log.Println(err)
+			vd.SetAlert(err)
+			u.renderLogin(w, r, vd)
+			return
 		}
+	case models.ErrNotFound, models.ErrAccountDisabled:
+		// fall through to the same response as a real account
+	default:
+		vd.SetAlert(err)
+		u.renderLogin(w, r, vd)
+		return
 	}
 
-	cookie := http.Cookie{
-		Name:  "remember_token",
-		Value: user.Remember,
+	views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "If an account exists for that address, a login link has been emailed to it.",
+	})
+}
+
+// MagicLink is where login links point. It only asks the user
+// to confirm, as mail scanners open links in emails and would
+// otherwise use them up.
+//
+// GET /login/magic
+func (u *Users) MagicLink(w http.ResponseWriter, r *http.Request) {
+	if !u.methods.MagicLink {
+		http.NotFound(w, r)
+		return
 	}
-	http.SetCookie(w, &cookie)
-	return nil
+	var vd views.Data
+	var form MagicLinkForm
+	vd.Yield = &form
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	u.MagicLinkView.Render(w, r, vd)
 }
 
-// displays cookie set on current user
-func (u *Users) CookieTest(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("remember_token")
-	if err != nil {
+// CompleteMagicLogin uses up a login link and signs its user
+// in, asking for their two factor code first if they use one.
+//
+// POST /login/magic/confirm
+func (u *Users) CompleteMagicLogin(w http.ResponseWriter, r *http.Request) {
+	if !u.methods.MagicLink {
+		http.NotFound(w, r)
+		return
+	}
+	var vd views.Data
+	var form MagicLinkForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		u.MagicLinkView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.us.CompleteMagicLogin(form.Token)
+	switch err {
+	case nil:
+	case models.ErrTokenInvalid:
+		views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelWarning,
+			Message: "That login link has expired or has already been used. Please ask for a new one.",
+		})
+		return
+	default:
+		vd.SetAlert(err)
+		u.MagicLinkView.Render(w, r, vd)
+		return
+	}
+
+	if user.TwoFactorEnabled() {
+		u.startTwoFactor(w, r, user, form.Remember)
+		return
+	}
+	if err := u.signIn(w, r, user, form.Remember); err != nil {
+		log.Println(err)
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
-	user, err := u.us.ByRemember(cookie.Value)
+	http.Redirect(w, r, afterLoginPath(user), http.StatusFound)
+}
+
+type LogoutForm struct {
+	Everywhere bool `schema:"everywhere"`
+}
+
+// Logout is used to delete a user's remember_token cookie and
+// revoke the session it belonged to, so a copied or stolen
+// cookie stops working straight away. If everywhere is set
+// every session the user has is revoked instead, signing them
+// out on all of their devices.
+//
+// POST /logout
+func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
+	var form LogoutForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+	}
+
+	u.cookies.Clear(w, cookie.Session)
+
+	user := context.User(r.Context())
+	session := context.Session(r.Context())
+	var err error
+	if form.Everywhere {
+		err = u.ss.DeleteByUserID(user.ID)
+	} else {
+		err = u.ss.Delete(session.ID)
+	}
 	if err != nil {
+		log.Println(err)
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// ResetPwForm is used to process both the forgot password
+// form and the reset password form.
+type ResetPwForm struct {
+	Email    string `schema:"email"`
+	Token    string `schema:"token"`
+	Password string `schema:"password"`
+}
+
+// ForgotPw displays the forgot password form, prefilling the
+// email address if one was provided in the URL.
+//
+// GET /forgot
+func (u *Users) ForgotPw(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	u.ForgotPwView.Render(w, r, vd)
+}
+
+// InitiateReset emails a password reset token to the user.
+// We show the same message whether or not the email address
+// belongs to an account, so the form can't be used to find
+// out who has signed up.
+//
+// POST /forgot
+func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.ForgotPwView.Render(w, r, vd)
+		return
+	}
+
+	user, token, err := u.us.InitiateReset(form.Email)
+	switch err {
+	case nil:
+		if err := u.emailer.ResetPw(user.Email, token); err != nil {
+			log.Println(err)
+			vd.SetAlert(err)
+			u.ForgotPwView.Render(w, r, vd)
+			return
+		}
+	case models.ErrNotFound:
+		// fall through to the same response as a real account
+	default:
+		vd.SetAlert(err)
+		u.ForgotPwView.Render(w, r, vd)
+		return
+	}
+
+	views.RedirectAlert(w, r, "/reset", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "If an account exists for that address, instructions for resetting your password have been emailed to it.",
+	})
+}
+
+// ResetPw displays the reset password form and has a method
+// so that we can prefill the form data with a token provided
+// via the URL query params.
+//
+// GET /reset
+func (u *Users) ResetPw(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	u.ResetPwView.Render(w, r, vd)
+}
+
+// CompleteReset processes the reset password form. Users with
+// two factor authentication are asked for their code before
+// they are signed in.
+//
+// POST /reset
+func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.ResetPwView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.us.CompleteReset(form.Token, form.Password)
+	if err != nil {
+		vd.SetAlert(err)
+		u.ResetPwView.Render(w, r, vd)
+		return
+	}
+	// whoever knew the old password may still be logged in
+	if err := u.ss.DeleteByUserID(user.ID); err != nil {
+		log.Println(err)
+	}
+
+	// a reset link proves the email, not the second factor
+	if user.TwoFactorEnabled() {
+		u.startTwoFactor(w, r, user, false)
+		return
+	}
+	if err := u.signIn(w, r, user, false); err != nil {
+		log.Println(err)
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
-	fmt.Fprintln(w, user)
+	views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Your password has been reset and you have been logged in!",
+	})
+}
+
+// Verify confirms the email address a verification link was
+// sent to.
+//
+// GET /verify
+func (u *Users) Verify(w http.ResponseWriter, r *http.Request) {
+	var form struct {
+		Token string `schema:"token"`
+	}
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	alert := views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Thanks! Your email address has been verified.",
+	}
+	if _, err := u.us.VerifyEmail(form.Token); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		alert = *vd.Alert
+	}
+	views.RedirectAlert(w, r, "/", http.StatusFound, alert)
+}
+
+// ResendVerification sends a new verification link to the
+// current user's unverified or pending email address.
+//
+// POST /verify/resend
+func (u *Users) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if user.Verified() && user.PendingEmail == "" {
+		views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelInfo,
+			Message: "Your email address is already verified.",
+		})
+		return
+	}
+	if err := sendVerification(u.us, u.emailer, user); err != nil {
+		views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+			Level:   views.AlertLevelError,
+			Message: views.AlertMessageGeneric,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "A new verification link is on its way to your inbox.",
+	})
+}
+
+// sendVerification emails a verification link for the user's
+// pending address, or their current one if nothing is pending.
+// Failures are logged, as they should never stop the user
+// from continuing to use the site.
+func sendVerification(us models.UserService, emailer *email.Client, user *models.User) error {
+	to := user.Email
+	if user.PendingEmail != "" {
+		to = user.PendingEmail
+	}
+	token, err := us.VerificationToken(user)
+	if err == nil {
+		err = emailer.VerifyEmail(to, token)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// signIn is used ot sign the given user in via cookies. Every
+// call starts a new session, so signing in on one device never
+// affects the sessions of another. If remember is set the
+// session survives the browser being closed.
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User, remember bool) error {
+	session := models.Session{
+		UserID:     user.ID,
+		UserAgent:  r.UserAgent(),
+		IP:         netutil.ClientIP(r),
+		Persistent: remember,
+		ExpiresAt:  time.Now().Add(u.cookies.Lifetime(remember)),
+	}
+	if err := u.ss.Create(&session); err != nil {
+		return err
+	}
+	u.cookies.SetSession(w, &session)
+	return nil
 }
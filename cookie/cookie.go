@@ -0,0 +1,114 @@
+// Package cookie writes the cookies that keep people logged
+// in, so every one of them gets the same security attributes
+// and expiry rules from our configuration.
+package cookie
+
+import (
+	"net/http"
+	"time"
+
+	"lenslocked.com/models"
+)
+
+const (
+	// Session holds the raw token of the visitor's session
+	Session = "remember_token"
+	// Impersonator holds an admin's own session token while
+	// they are acting as another user
+	Impersonator = "impersonator_token"
+	// TwoFactor holds the challenge for a login that is
+	// waiting for a two factor code
+	TwoFactor = "two_factor"
+)
+
+// Config is used to set up a Manager
+type Config struct {
+	// Secure cookies are only sent over HTTPS
+	Secure   bool
+	SameSite http.SameSite
+	// Domain is left empty to limit cookies to the exact host
+	Domain string
+	// SessionTimeout is how long a session lasts without being
+	// used when the user didn't ask to be remembered
+	SessionTimeout time.Duration
+	// RememberFor is how long a session lasts without being
+	// used when the user ticked "remember me"
+	RememberFor time.Duration
+}
+
+// Manager sets and clears cookies. Every cookie it writes is
+// HttpOnly, scoped to the whole site, and has the Secure and
+// SameSite attributes from its Config.
+type Manager struct {
+	cfg Config
+}
+
+func NewManager(cfg Config) *Manager {
+	return &Manager{
+		cfg: cfg,
+	}
+}
+
+// Set writes the cookie name. A zero expires makes it a
+// browser session cookie, which is gone once the browser is
+// closed.
+func (m *Manager) Set(w http.ResponseWriter, name, value string, expires time.Time) {
+	cookie := m.cookie(name, value)
+	if !expires.IsZero() {
+		cookie.Expires = expires
+		cookie.MaxAge = int(time.Until(expires).Seconds())
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// Clear tells the browser to delete the cookie name
+func (m *Manager) Clear(w http.ResponseWriter, name string) {
+	cookie := m.cookie(name, "")
+	cookie.Expires = time.Unix(0, 0)
+	cookie.MaxAge = -1
+	http.SetCookie(w, &cookie)
+}
+
+func (m *Manager) cookie(name, value string) http.Cookie {
+	return http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   m.cfg.Domain,
+		Secure:   m.cfg.Secure,
+		HttpOnly: true,
+		SameSite: m.cfg.SameSite,
+	}
+}
+
+// Lifetime returns how long a session lasts without being
+// used. Persistent sessions are the ones where the user
+// ticked "remember me".
+func (m *Manager) Lifetime(persistent bool) time.Duration {
+	if persistent {
+		return m.cfg.RememberFor
+	}
+	return m.cfg.SessionTimeout
+}
+
+// SetSession writes the session cookie for session, whose
+// raw Token has to be set. Persistent and impersonated
+// sessions get a cookie that expires along with the session,
+// all others last until the browser is closed.
+func (m *Manager) SetSession(w http.ResponseWriter, session *models.Session) {
+	var expires time.Time
+	if session.Persistent || session.Impersonated() {
+		expires = session.ExpiresAt
+	}
+	m.Set(w, Session, session.Token, expires)
+}
+
+// Renew slides the expiry of session forward, as it is still
+// being used. Impersonated sessions have a hard time limit and
+// are never renewed.
+func (m *Manager) Renew(session *models.Session) {
+	if session.Impersonated() {
+		return
+	}
+	session.ExpiresAt = time.Now().Add(m.Lifetime(session.Persistent))
+}
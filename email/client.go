@@ -0,0 +1,243 @@
+package email
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	resetSubject  = "Instructions for resetting your password"
+	resetURL      = "/reset"
+	verifySubject = "Please verify your email address"
+	verifyURL     = "/verify"
+	magicSubject  = "Your Lenslocked login link"
+	magicURL      = "/login/magic"
+	inviteSubject = "You've been invited to Lenslocked"
+	inviteURL     = "/signup"
+)
+
+const inviteTextTmpl = `Hi there!
+
+%s has invited you to join Lenslocked. Follow the link below to create your account:
+
+%s
+
+The invitation expires on %s.
+
+Best,
+Lenslocked Support
+`
+
+const inviteHTMLTmpl = `Hi there!<br/>
+<br/>
+%s has invited you to join Lenslocked. Follow the link below to create your account:<br/>
+<br/>
+<a href="%s">%s</a><br/>
+<br/>
+The invitation expires on %s.<br/>
+<br/>
+Best,<br/>
+Lenslocked Support<br/>
+`
+
+const magicTextTmpl = `Hi there!
+
+Follow the link below to log in to Lenslocked:
+
+%s
+
+The link is valid for 15 minutes and can only be used once. If you didn't ask to log in, you can safely ignore this email.
+
+Best,
+Lenslocked Support
+`
+
+const magicHTMLTmpl = `Hi there!<br/>
+<br/>
+Follow the link below to log in to Lenslocked:<br/>
+<br/>
+<a href="%s">%s</a><br/>
+<br/>
+The link is valid for 15 minutes and can only be used once. If you didn't ask to log in, you can safely ignore this email.<br/>
+<br/>
+Best,<br/>
+Lenslocked Support<br/>
+`
+
+const verifyTextTmpl = `Hi there!
+
+Please confirm that this is your email address by following the link below:
+
+%s
+
+The link is valid for 72 hours. If you didn't sign up for Lenslocked or change your email address, you can safely ignore this email.
+
+Best,
+Lenslocked Support
+`
+
+const verifyHTMLTmpl = `Hi there!<br/>
+<br/>
+Please confirm that this is your email address by following the link below:<br/>
+<br/>
+<a href="%s">%s</a><br/>
+<br/>
+The link is valid for 72 hours. If you didn't sign up for Lenslocked or change your email address, you can safely ignore this email.<br/>
+<br/>
+Best,<br/>
+Lenslocked Support<br/>
+`
+
+const resetTextTmpl = `Hi there!
+
+It appears that you have requested a password reset. If this was you, please follow the link below to update your password:
+
+%s
+
+If you are asked for a token, please use the following value:
+
+%s
+
+If you didn't request a password reset you can safely ignore this email and your account will not be changed.
+
+Best,
+Lenslocked Support
+`
+
+const resetHTMLTmpl = `Hi there!<br/>
+<br/>
+It appears that you have requested a password reset. If this was you, please follow the link below to update your password:<br/>
+<br/>
+<a href="%s">%s</a><br/>
+<br/>
+If you are asked for a token, please use the following value:<br/>
+<br/>
+%s<br/>
+<br/>
+If you didn't request a password reset you can safely ignore this email and your account will not be changed.<br/>
+<br/>
+Best,<br/>
+Lenslocked Support<br/>
+`
+
+// ClientConfig is used to configure a Client
+type ClientConfig func(*Client)
+
+// WithSender sets the From address used on every email
+func WithSender(name, email string) ClientConfig {
+	return func(c *Client) {
+		c.from = buildEmail(name, email)
+	}
+}
+
+// WithMailer sets the transport used to deliver emails
+func WithMailer(m Mailer) ClientConfig {
+	return func(c *Client) {
+		c.mailer = m
+	}
+}
+
+// WithBaseURL sets the scheme and host used to build links,
+// eg "https://lenslocked.com"
+func WithBaseURL(baseURL string) ClientConfig {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// NewClient returns a Client that, unless configured
+// otherwise, logs emails to stdout instead of sending them.
+func NewClient(opts ...ClientConfig) *Client {
+	client := Client{
+		from:    "Lenslocked Support <support@lenslocked.com>",
+		mailer:  &LogMailer{W: os.Stdout},
+		baseURL: "http://localhost:3000",
+	}
+	for _, opt := range opts {
+		opt(&client)
+	}
+	return &client
+}
+
+// Client builds the emails our application sends and hands
+// them off to a Mailer.
+type Client struct {
+	from    string
+	mailer  Mailer
+	baseURL string
+}
+
+// ResetPw sends the password reset instructions to toEmail
+func (c *Client) ResetPw(toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	resetUrl := c.baseURL + resetURL + "?" + v.Encode()
+	return c.mailer.Send(Message{
+		From:    c.from,
+		To:      toEmail,
+		Subject: resetSubject,
+		Text:    fmt.Sprintf(resetTextTmpl, resetUrl, token),
+		HTML:    fmt.Sprintf(resetHTMLTmpl, resetUrl, resetUrl, token),
+	})
+}
+
+// VerifyEmail sends a link to toEmail that confirms the
+// recipient owns the address
+func (c *Client) VerifyEmail(toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	verifyUrl := c.baseURL + verifyURL + "?" + v.Encode()
+	return c.mailer.Send(Message{
+		From:    c.from,
+		To:      toEmail,
+		Subject: verifySubject,
+		Text:    fmt.Sprintf(verifyTextTmpl, verifyUrl),
+		HTML:    fmt.Sprintf(verifyHTMLTmpl, verifyUrl, verifyUrl),
+	})
+}
+
+// MagicLink sends a single use login link to toEmail
+func (c *Client) MagicLink(toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	magicUrl := c.baseURL + magicURL + "?" + v.Encode()
+	return c.mailer.Send(Message{
+		From:    c.from,
+		To:      toEmail,
+		Subject: magicSubject,
+		Text:    fmt.Sprintf(magicTextTmpl, magicUrl),
+		HTML:    fmt.Sprintf(magicHTMLTmpl, magicUrl, magicUrl),
+	})
+}
+
+// Invite sends an invitation to sign up to toEmail. from is
+// the name of the person who sent it.
+func (c *Client) Invite(toEmail, from, code string, expiresAt time.Time) error {
+	inviteUrl := c.InviteURL(code)
+	expires := expiresAt.Format("January 2, 2006")
+	return c.mailer.Send(Message{
+		From:    c.from,
+		To:      toEmail,
+		Subject: inviteSubject,
+		Text:    fmt.Sprintf(inviteTextTmpl, from, inviteUrl, expires),
+		HTML:    fmt.Sprintf(inviteHTMLTmpl, html.EscapeString(from), inviteUrl, inviteUrl, expires),
+	})
+}
+
+// InviteURL is the signup link for the invitation with code,
+// for people to share themselves
+func (c *Client) InviteURL(code string) string {
+	v := url.Values{}
+	v.Set("invite", code)
+	return c.baseURL + inviteURL + "?" + v.Encode()
+}
+
+func buildEmail(name, email string) string {
+	if name == "" {
+		return email
+	}
+	return fmt.Sprintf("%s <%s>", name, email)
+}
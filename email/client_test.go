@@ -0,0 +1,95 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestClient() (*Client, *MemoryMailer) {
+	mm := &MemoryMailer{}
+	c := NewClient(
+		WithSender("Lenslocked Support", "support@example.com"),
+		WithBaseURL("https://example.com"),
+		WithMailer(mm),
+	)
+	return c, mm
+}
+
+func TestResetPw(t *testing.T) {
+	c, mm := newTestClient()
+	if err := c.ResetPw("jon@example.com", "a+b/c="); err != nil {
+		t.Fatalf("ResetPw() err = %v", err)
+	}
+	sent := mm.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	msg := sent[0]
+	if msg.To != "jon@example.com" {
+		t.Errorf("To = %q, want %q", msg.To, "jon@example.com")
+	}
+	if want := "Lenslocked Support <support@example.com>"; msg.From != want {
+		t.Errorf("From = %q, want %q", msg.From, want)
+	}
+	if msg.Subject != resetSubject {
+		t.Errorf("Subject = %q, want %q", msg.Subject, resetSubject)
+	}
+	// the token has to survive being put in a URL
+	link := "https://example.com/reset?token=a%2Bb%2Fc%3D"
+	for name, body := range map[string]string{"Text": msg.Text, "HTML": msg.HTML} {
+		if !strings.Contains(body, link) {
+			t.Errorf("%s doesn't contain the reset link %q:\n%s", name, link, body)
+		}
+		if !strings.Contains(body, "a+b/c=") {
+			t.Errorf("%s doesn't contain the raw token:\n%s", name, body)
+		}
+	}
+}
+
+func TestMemoryMailerSentIsACopy(t *testing.T) {
+	mm := &MemoryMailer{}
+	mm.Send(Message{To: "a@example.com"})
+	sent := mm.Sent()
+	sent[0].To = "changed@example.com"
+	if got := mm.Sent()[0].To; got != "a@example.com" {
+		t.Errorf("Sent()[0].To = %q after changing the copy, want %q", got, "a@example.com")
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tests := map[string]string{
+		"support@example.com":                      "support@example.com",
+		"Lenslocked Support <support@example.com>": "support@example.com",
+	}
+	for from, want := range tests {
+		if got := address(from); got != want {
+			t.Errorf("address(%q) = %q, want %q", from, got, want)
+		}
+	}
+}
+
+func TestBuildMIME(t *testing.T) {
+	msg := Message{
+		From:    "a@example.com",
+		To:      "b@example.com",
+		Subject: "Hi",
+		Text:    "plain body",
+	}
+	got := string(buildMIME(msg))
+	if !strings.Contains(got, "Content-Type: text/plain; charset=UTF-8\r\n\r\nplain body") {
+		t.Errorf("text only message:\n%s", got)
+	}
+
+	msg.HTML = "<b>html body</b>"
+	got = string(buildMIME(msg))
+	for _, part := range []string{
+		"Content-Type: multipart/alternative; boundary=" + mimeBoundary,
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\nplain body",
+		"Content-Type: text/html; charset=UTF-8\r\n\r\n<b>html body</b>",
+		"--" + mimeBoundary + "--",
+	} {
+		if !strings.Contains(got, part) {
+			t.Errorf("message with HTML is missing %q:\n%s", part, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package email
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+// Message is a single email ready to be delivered
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer is anything that can deliver a Message. The Client
+// only ever talks to this interface so the transport can be
+// swapped out, eg for LogMailer during development or for
+// MemoryMailer in tests.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// LogMailer writes every message to W instead of sending it.
+// This is the local stand-in used when no SMTP server is set up.
+type LogMailer struct {
+	W io.Writer
+}
+
+func (lm *LogMailer) Send(msg Message) error {
+	_, err := fmt.Fprintf(lm.W, "From: %s\nTo: %s\nSubject: %s\n\n%s\n",
+		msg.From, msg.To, msg.Subject, msg.Text)
+	return err
+}
+
+// MemoryMailer keeps every message it is asked to send in
+// memory so tests can inspect what would have gone out.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (mm *MemoryMailer) Send(msg Message) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.sent = append(mm.sent, msg)
+	return nil
+}
+
+// Sent returns a copy of every message sent so far
+func (mm *MemoryMailer) Sent() []Message {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	ret := make([]Message, len(mm.sent))
+	copy(ret, mm.sent)
+	return ret
+}
+
+// SMTPMailer delivers messages through an SMTP server using
+// PLAIN auth when a username is provided.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func (sm *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if sm.Username != "" {
+		auth = smtp.PlainAuth("", sm.Username, sm.Password, sm.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", sm.Host, sm.Port)
+	return smtp.SendMail(addr, auth, address(msg.From), []string{msg.To}, buildMIME(msg))
+}
+
+// address strips the display name from "Name <email>" style addresses
+func address(from string) string {
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		return strings.TrimSuffix(from[i+1:], ">")
+	}
+	return from
+}
+
+const mimeBoundary = "lenslocked-boundary"
+
+func buildMIME(msg Message) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&sb, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&sb, "Subject: %s\r\n", msg.Subject)
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		sb.WriteString(msg.Text)
+		return []byte(sb.String())
+	}
+	fmt.Fprintf(&sb, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mimeBoundary)
+	fmt.Fprintf(&sb, "--%s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", mimeBoundary, msg.Text)
+	fmt.Fprintf(&sb, "--%s\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n", mimeBoundary, msg.HTML)
+	fmt.Fprintf(&sb, "--%s--\r\n", mimeBoundary)
+	return []byte(sb.String())
+}
@@ -0,0 +1,47 @@
+package email
+
+import (
+	"context"
+
+	"lenslocked.com/jobs"
+)
+
+// SendJob delivers a message in the background. Messages can
+// hold secrets such as password reset links, so the job is
+// Sensitive: the message is deleted along with the job when it
+// is sent, and wiped if the job dies.
+type SendJob struct {
+	Message Message
+}
+
+var _ jobs.Sensitive = SendJob{}
+
+func (SendJob) JobType() string {
+	return "email.send"
+}
+
+func (SendJob) Sensitive() {}
+
+// QueueMailer is a Mailer that enqueues every message as a job,
+// which then delivers it with another Mailer. Sending returns
+// as soon as the message is stored, and delivery is retried if
+// the mail server is down.
+type QueueMailer struct {
+	queue *jobs.Queue
+}
+
+var _ Mailer = &QueueMailer{}
+
+// NewQueueMailer returns a QueueMailer that enqueues messages
+// in queue, and registers the handler that delivers them with
+// m.
+func NewQueueMailer(queue *jobs.Queue, m Mailer) *QueueMailer {
+	queue.Handle(func(ctx context.Context, job SendJob) error {
+		return m.Send(job.Message)
+	})
+	return &QueueMailer{queue: queue}
+}
+
+func (qm *QueueMailer) Send(msg Message) error {
+	return qm.queue.Enqueue(SendJob{Message: msg})
+}
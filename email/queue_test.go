@@ -0,0 +1,57 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"lenslocked.com/jobs"
+)
+
+// failingMailer never manages to send anything
+type failingMailer struct{}
+
+func (failingMailer) Send(msg Message) error {
+	return errors.New("connection refused")
+}
+
+func TestQueueMailer(t *testing.T) {
+	store := jobs.NewMemoryStore()
+	queue := jobs.NewQueue(store, jobs.Config{})
+	mm := &MemoryMailer{}
+	c := NewClient(WithBaseURL("https://example.com"), WithMailer(NewQueueMailer(queue, mm)))
+	if err := c.ResetPw("jon@example.com", "secret-token"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mm.Sent()) != 0 {
+		t.Fatalf("message sent before the job ran")
+	}
+	if n, err := queue.RunDue(); n != 1 || err != nil {
+		t.Fatalf("RunDue() = %d, %v, want 1 job run", n, err)
+	}
+	if sent := mm.Sent(); len(sent) != 1 || sent[0].To != "jon@example.com" {
+		t.Errorf("sent = %+v, want the reset email", sent)
+	}
+	if left := store.Jobs(); len(left) != 0 {
+		t.Errorf("jobs left = %+v, want the sent one deleted", left)
+	}
+}
+
+func TestQueueMailerWipesDeadJobs(t *testing.T) {
+	store := jobs.NewMemoryStore()
+	queue := jobs.NewQueue(store, jobs.Config{MaxAttempts: 1})
+	c := NewClient(WithBaseURL("https://example.com"), WithMailer(NewQueueMailer(queue, failingMailer{})))
+	if err := c.ResetPw("jon@example.com", "secret-token"); err != nil {
+		t.Fatal(err)
+	}
+	queue.RunDue()
+	dead := store.Jobs()
+	if len(dead) != 1 || dead[0].Status != jobs.StatusDead {
+		t.Fatalf("jobs = %+v, want one dead job", dead)
+	}
+	for _, field := range []string{dead[0].Payload, dead[0].LastError} {
+		if strings.Contains(field, "secret-token") {
+			t.Errorf("dead job kept the reset token: %q", field)
+		}
+	}
+}
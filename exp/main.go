@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"golang.org/x/crypto/bcrypt"
+	"lenslocked.com/hash"
 	"lenslocked.com/models"
 )
 
@@ -16,7 +18,14 @@ const (
 
 func main() {
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable", host, port, user, dbname)
-	services, err := models.NewServices(psqlInfo)
+	hmacKeys, err := hash.NewKeyring("", map[string]string{"": "secret-hmac-key"})
+	if err != nil {
+		panic(err)
+	}
+	services, err := models.NewServices(
+		models.WithGorm("postgres", psqlInfo),
+		models.WithUser("secret-random-string", hash.NewBcrypt(bcrypt.DefaultCost), hmacKeys),
+	)
 	if err != nil {
 		panic(err)
 	}
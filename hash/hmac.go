@@ -5,22 +5,35 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"hash"
+	"sync"
 )
 
+// NewHMAC returns an HMAC that hashes with SHA-256 and the
+// provided key.
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
+	k := []byte(key)
 	return HMAC{
-		hmac: h,
+		pool: &sync.Pool{
+			New: func() interface{} {
+				return hmac.New(sha256.New, k)
+			},
+		},
 	}
 }
 
+// HMAC is safe for concurrent use. A hash.Hash keeps state
+// between Write and Sum, so rather than sharing one, every
+// call to Hash borrows a hasher from a pool and returns it
+// when it is done. Copies of an HMAC share the same pool.
 type HMAC struct {
-	hmac hash.Hash
+	pool *sync.Pool
 }
 
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b := h.hmac.Sum(nil)
+	mac := h.pool.Get().(hash.Hash)
+	defer h.pool.Put(mac)
+	mac.Reset()
+	mac.Write([]byte(input))
+	b := mac.Sum(nil)
 	return base64.URLEncoding.EncodeToString(b)
 }
@@ -0,0 +1,73 @@
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// expectedHMAC computes the hash from scratch, with nothing
+// shared between calls
+func expectedHMAC(key, input string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(input))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMAC(t *testing.T) {
+	h := NewHMAC("secret-key")
+	for _, input := range []string{"", "a", "remember-token", "a longer input with spaces"} {
+		if got, want := h.Hash(input), expectedHMAC("secret-key", input); got != want {
+			t.Errorf("Hash(%q) = %q, want %q", input, got, want)
+		}
+	}
+	if NewHMAC("other-key").Hash("a") == h.Hash("a") {
+		t.Errorf("different keys gave the same hash")
+	}
+}
+
+// TestHMACParallel hashes different inputs from many goroutines
+// at once. Run it with -race; a hasher shared without the pool
+// would mix up the inputs.
+func TestHMACParallel(t *testing.T) {
+	h := NewHMAC("secret-key")
+	const goroutines, perGoroutine = 16, 200
+	want := make([]string, perGoroutine)
+	for i := range want {
+		want[i] = expectedHMAC("secret-key", fmt.Sprint("token-", i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		// copies of an HMAC share its pool
+		go func(h HMAC) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				if got := h.Hash(fmt.Sprint("token-", i)); got != want[i] {
+					errs <- fmt.Sprintf("Hash(%q) = %q, want %q", fmt.Sprint("token-", i), got, want[i])
+					return
+				}
+			}
+		}(h)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func BenchmarkHMAC(b *testing.B) {
+	h := NewHMAC("secret-key")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.Hash("remember-token")
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package hash
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// keyIDSep separates the key ID from the digest. It can't
+// appear in URL safe base64, so digests never contain it.
+const keyIDSep = ":"
+
+// NewKeyring returns a Keyring that hashes with the key named
+// primaryID and can still verify digests made with any of the
+// other keys.
+//
+// Digests are stored as "<key ID>:<digest>" so we know which
+// key made them. The key ID "" is special: its digests have
+// no prefix, which is how digests were stored before keys were
+// rotated, so the original key should be kept under "" until
+// everything hashed with it has been upgraded or expired.
+func NewKeyring(primaryID string, keys map[string]string) (*Keyring, error) {
+	if _, ok := keys[primaryID]; !ok {
+		return nil, fmt.Errorf("hash: primary key %q is not in the keyring", primaryID)
+	}
+	kr := Keyring{
+		primary: primaryID,
+		keys:    make(map[string]HMAC, len(keys)),
+	}
+	for id, key := range keys {
+		if strings.Contains(id, keyIDSep) {
+			return nil, fmt.Errorf("hash: key ID %q can not contain %q", id, keyIDSep)
+		}
+		if key == "" {
+			return nil, errors.New("hash: HMAC keys can not be empty")
+		}
+		kr.keys[id] = NewHMAC(key)
+		if id != primaryID {
+			kr.older = append(kr.older, id)
+		}
+	}
+	sort.Strings(kr.older)
+	return &kr, nil
+}
+
+// Keyring hashes values with HMAC while supporting key
+// rotation: a single primary key is used for new digests, and
+// any number of older keys are kept around for verification.
+// A Keyring is never modified after NewKeyring returns it, so
+// it is safe for concurrent use.
+type Keyring struct {
+	primary string
+	older   []string
+	keys    map[string]HMAC
+}
+
+// Hash returns the digest of input under the primary key
+func (kr *Keyring) Hash(input string) string {
+	return kr.hashWith(kr.primary, input)
+}
+
+// Candidates returns the digest of input under every key in
+// the keyring, primary first. It is used to look up values
+// that may have been stored before the primary key changed.
+func (kr *Keyring) Candidates(input string) []string {
+	ret := make([]string, 0, len(kr.older)+1)
+	ret = append(ret, kr.Hash(input))
+	for _, id := range kr.older {
+		ret = append(ret, kr.hashWith(id, input))
+	}
+	return ret
+}
+
+// Verify reports whether digest is the digest of input under
+// the key it is tagged with.
+func (kr *Keyring) Verify(input, digest string) bool {
+	id := keyID(digest)
+	if _, ok := kr.keys[id]; !ok {
+		return false
+	}
+	return hmac.Equal([]byte(kr.hashWith(id, input)), []byte(digest))
+}
+
+// IsPrimary reports whether digest was made with the primary
+// key. Anything else should be re-hashed when next used.
+func (kr *Keyring) IsPrimary(digest string) bool {
+	return keyID(digest) == kr.primary
+}
+
+func (kr *Keyring) hashWith(id, input string) string {
+	digest := kr.keys[id].Hash(input)
+	if id == "" {
+		return digest
+	}
+	return id + keyIDSep + digest
+}
+
+func keyID(digest string) string {
+	if i := strings.Index(digest, keyIDSep); i >= 0 {
+		return digest[:i]
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, primaryID string) *Keyring {
+	t.Helper()
+	kr, err := NewKeyring(primaryID, map[string]string{
+		"":   "original-key",
+		"v1": "first-key",
+		"v2": "second-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestNewKeyringRejects(t *testing.T) {
+	tests := map[string]struct {
+		primaryID string
+		keys      map[string]string
+	}{
+		"missing primary": {"v2", map[string]string{"v1": "first-key"}},
+		"separator in id": {"v:1", map[string]string{"v:1": "first-key"}},
+		"empty key":       {"v1", map[string]string{"v1": ""}},
+	}
+	for name, tc := range tests {
+		if _, err := NewKeyring(tc.primaryID, tc.keys); err == nil {
+			t.Errorf("%s: NewKeyring() err = nil", name)
+		}
+	}
+}
+
+func TestKeyringHash(t *testing.T) {
+	kr := newTestKeyring(t, "v2")
+	digest := kr.Hash("token")
+	if want := "v2:" + expectedHMAC("second-key", "token"); digest != want {
+		t.Errorf("Hash() = %q, want %q", digest, want)
+	}
+	if !kr.IsPrimary(digest) {
+		t.Errorf("IsPrimary(%q) = false", digest)
+	}
+	if !kr.Verify("token", digest) {
+		t.Errorf("Verify(%q) = false", digest)
+	}
+	if kr.Verify("other token", digest) {
+		t.Errorf("Verify() of another input = true")
+	}
+}
+
+func TestKeyringCandidates(t *testing.T) {
+	kr := newTestKeyring(t, "v2")
+	want := []string{
+		"v2:" + expectedHMAC("second-key", "token"),
+		expectedHMAC("original-key", "token"),
+		"v1:" + expectedHMAC("first-key", "token"),
+	}
+	got := kr.Candidates("token")
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Candidates() = %q, want %q", got, want)
+	}
+}
+
+func TestKeyringOlderKeys(t *testing.T) {
+	kr := newTestKeyring(t, "v2")
+	tests := map[string]string{
+		"legacy": expectedHMAC("original-key", "token"),
+		"v1":     "v1:" + expectedHMAC("first-key", "token"),
+	}
+	for name, digest := range tests {
+		if !kr.Verify("token", digest) {
+			t.Errorf("%s: Verify(%q) = false", name, digest)
+		}
+		if kr.IsPrimary(digest) {
+			t.Errorf("%s: IsPrimary(%q) = true", name, digest)
+		}
+	}
+
+	// the legacy key can still be the primary one
+	legacy := newTestKeyring(t, "")
+	digest := legacy.Hash("token")
+	if digest != expectedHMAC("original-key", "token") {
+		t.Errorf("Hash() with the legacy key = %q, want an un-prefixed digest", digest)
+	}
+	if !legacy.IsPrimary(digest) {
+		t.Errorf("IsPrimary(%q) = false", digest)
+	}
+}
+
+func TestKeyringUnknownKey(t *testing.T) {
+	kr := newTestKeyring(t, "v2")
+	tests := map[string]string{
+		"unknown id":  "v3:" + expectedHMAC("second-key", "token"),
+		"wrong id":    "v1:" + expectedHMAC("second-key", "token"),
+		"unknown key": "v2:" + expectedHMAC("stolen-key", "token"),
+		"not base64":  "v2:not a digest",
+	}
+	for name, digest := range tests {
+		if kr.Verify("token", digest) {
+			t.Errorf("%s: Verify(%q) = true", name, digest)
+		}
+	}
+	if kr.IsPrimary("v3:" + expectedHMAC("second-key", "token")) {
+		t.Errorf("IsPrimary() of an unknown key = true")
+	}
+}
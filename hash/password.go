@@ -0,0 +1,220 @@
+package hash
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"lenslocked.com/rand"
+)
+
+// ErrUnknownHash is returned when an encoded hash wasn't
+// produced by any of the algorithms we know about
+var ErrUnknownHash = errors.New("hash: unknown password hash format")
+
+// PasswordHasher hashes passwords into an encoded string that
+// records the algorithm and parameters used, so hashes made
+// with older settings can still be verified later on.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+	// Verify reports whether password matches the encoded hash
+	Verify(password, encoded string) (bool, error)
+	// Identifies reports whether encoded was produced by this
+	// hasher's algorithm, regardless of its parameters
+	Identifies(encoded string) bool
+	// NeedsRehash reports whether encoded should be replaced by
+	// a new hash made with this hasher's current settings
+	NeedsRehash(encoded string) bool
+}
+
+// NewBcrypt returns a PasswordHasher using bcrypt with the
+// provided cost. bcrypt hashes are already self describing,
+// eg "$2a$10$...", so they are stored as is.
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{cost: cost}
+}
+
+type Bcrypt struct {
+	cost int
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedBytes), nil
+}
+
+func (b *Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (b *Bcrypt) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
+
+// Argon2Params are the tunable costs of argon2id
+type Argon2Params struct {
+	// Time is the number of passes over the memory
+	Time uint32
+	// Memory is the amount of memory used in KiB
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultArgon2Params follow the second recommended option of
+// RFC 9106 for memory constrained environments
+var DefaultArgon2Params = Argon2Params{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+const (
+	argon2SaltBytes = 16
+	argon2KeyBytes  = 32
+	argon2Prefix    = "$argon2id$"
+)
+
+// NewArgon2id returns a PasswordHasher using argon2id. Hashes
+// use the PHC string format, eg
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func NewArgon2id(params Argon2Params) *Argon2id {
+	return &Argon2id{params: params}
+}
+
+type Argon2id struct {
+	params Argon2Params
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt, err := rand.Bytes(argon2SaltBytes)
+	if err != nil {
+		return "", err
+	}
+	p := a.params
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyBytes)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2id) Verify(password, encoded string) (bool, error) {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a *Argon2id) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2Prefix)
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	p, _, key, err := decodeArgon2id(encoded)
+	return err != nil || p != a.params || len(key) != argon2KeyBytes
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(encoded, "$")
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, err
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("hash: unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	return p, salt, key, nil
+}
+
+// NewPasswords returns a PasswordHasher that hashes new
+// passwords with primary, but can still verify hashes made by
+// any of the legacy hashers. Every hash not made by primary
+// with its current settings needs a rehash.
+func NewPasswords(primary PasswordHasher, legacy ...PasswordHasher) *Passwords {
+	return &Passwords{
+		primary: primary,
+		legacy:  legacy,
+	}
+}
+
+type Passwords struct {
+	primary PasswordHasher
+	legacy  []PasswordHasher
+}
+
+func (ps *Passwords) Hash(password string) (string, error) {
+	return ps.primary.Hash(password)
+}
+
+func (ps *Passwords) Verify(password, encoded string) (bool, error) {
+	h := ps.hasherFor(encoded)
+	if h == nil {
+		return false, ErrUnknownHash
+	}
+	return h.Verify(password, encoded)
+}
+
+func (ps *Passwords) Identifies(encoded string) bool {
+	return ps.hasherFor(encoded) != nil
+}
+
+func (ps *Passwords) NeedsRehash(encoded string) bool {
+	if !ps.primary.Identifies(encoded) {
+		return true
+	}
+	return ps.primary.NeedsRehash(encoded)
+}
+
+func (ps *Passwords) hasherFor(encoded string) PasswordHasher {
+	if ps.primary.Identifies(encoded) {
+		return ps.primary
+	}
+	for _, h := range ps.legacy {
+		if h.Identifies(encoded) {
+			return h
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2Params keep the tests fast; they are far too cheap
+// to use for real passwords
+var testArgon2Params = Argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"bcrypt":   NewBcrypt(bcrypt.MinCost),
+		"argon2id": NewArgon2id(testArgon2Params),
+	}
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !h.Identifies(encoded) {
+				t.Errorf("Identifies(%q) = false", encoded)
+			}
+			if h.NeedsRehash(encoded) {
+				t.Errorf("NeedsRehash(%q) = true for a fresh hash", encoded)
+			}
+			if ok, err := h.Verify("correct horse", encoded); !ok || err != nil {
+				t.Errorf("Verify() with the right password = %v, %v", ok, err)
+			}
+			if ok, err := h.Verify("battery staple", encoded); ok || err != nil {
+				t.Errorf("Verify() with the wrong password = %v, %v", ok, err)
+			}
+			again, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again == encoded {
+				t.Errorf("hashing the same password twice gave the same hash, is it salted?")
+			}
+		})
+	}
+}
+
+func TestArgon2idEncoding(t *testing.T) {
+	a := NewArgon2id(testArgon2Params)
+	encoded, err := a.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "$argon2id$v=19$m=64,t=1,p=1$"
+	if !strings.HasPrefix(encoded, prefix) {
+		t.Errorf("Hash() = %q, want it to start with %q", encoded, prefix)
+	}
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatalf("decodeArgon2id(%q) err = %v", encoded, err)
+	}
+	if p != testArgon2Params {
+		t.Errorf("decoded params = %+v, want %+v", p, testArgon2Params)
+	}
+	if len(salt) != argon2SaltBytes || len(key) != argon2KeyBytes {
+		t.Errorf("decoded %d byte salt and %d byte key, want %d and %d",
+			len(salt), len(key), argon2SaltBytes, argon2KeyBytes)
+	}
+}
+
+func TestPasswordHashersMalformed(t *testing.T) {
+	good, err := NewArgon2id(testArgon2Params).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(good, "$")
+	argon2 := func(version, params, salt, key string) string {
+		return strings.Join([]string{"", "argon2id", version, params, salt, key}, "$")
+	}
+	tests := map[string]struct {
+		h       PasswordHasher
+		encoded string
+	}{
+		"argon2id empty":        {NewArgon2id(testArgon2Params), ""},
+		"argon2id truncated":    {NewArgon2id(testArgon2Params), "$argon2id$v=19$m=64,t=1,p=1"},
+		"argon2i":               {NewArgon2id(testArgon2Params), strings.Replace(good, "argon2id", "argon2i", 1)},
+		"argon2id old version":  {NewArgon2id(testArgon2Params), argon2("v=16", parts[3], parts[4], parts[5])},
+		"argon2id bad version":  {NewArgon2id(testArgon2Params), argon2("version", parts[3], parts[4], parts[5])},
+		"argon2id bad params":   {NewArgon2id(testArgon2Params), argon2(parts[2], "m=64,t=one,p=1", parts[4], parts[5])},
+		"argon2id bad salt":     {NewArgon2id(testArgon2Params), argon2(parts[2], parts[3], "not base64!", parts[5])},
+		"argon2id bad key":      {NewArgon2id(testArgon2Params), argon2(parts[2], parts[3], parts[4], "not base64!")},
+		"bcrypt empty":          {NewBcrypt(bcrypt.MinCost), ""},
+		"bcrypt truncated":      {NewBcrypt(bcrypt.MinCost), "$2a$04$"},
+		"bcrypt given argon2id": {NewBcrypt(bcrypt.MinCost), good},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if ok, err := tc.h.Verify("correct horse", tc.encoded); ok || err == nil {
+				t.Errorf("Verify(%q) = %v, %v, want an error", tc.encoded, ok, err)
+			}
+			if !tc.h.NeedsRehash(tc.encoded) {
+				t.Errorf("NeedsRehash(%q) = false", tc.encoded)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon2Hash, err := NewArgon2id(testArgon2Params).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := NewBcrypt(bcrypt.MinCost).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := func(fn func(p *Argon2Params)) PasswordHasher {
+		p := testArgon2Params
+		fn(&p)
+		return NewArgon2id(p)
+	}
+	tests := []struct {
+		name    string
+		h       PasswordHasher
+		encoded string
+		want    bool
+	}{
+		{"argon2id same params", NewArgon2id(testArgon2Params), argon2Hash, false},
+		{"argon2id more time", changed(func(p *Argon2Params) { p.Time++ }), argon2Hash, true},
+		{"argon2id more memory", changed(func(p *Argon2Params) { p.Memory *= 2 }), argon2Hash, true},
+		{"argon2id more threads", changed(func(p *Argon2Params) { p.Threads++ }), argon2Hash, true},
+		{"bcrypt same cost", NewBcrypt(bcrypt.MinCost), bcryptHash, false},
+		{"bcrypt higher cost", NewBcrypt(bcrypt.MinCost + 1), bcryptHash, true},
+		{"argon2id given bcrypt", NewArgon2id(testArgon2Params), bcryptHash, true},
+		{"bcrypt given argon2id", NewBcrypt(bcrypt.MinCost), argon2Hash, true},
+	}
+	for _, tc := range tests {
+		if got := tc.h.NeedsRehash(tc.encoded); got != tc.want {
+			t.Errorf("%s: NeedsRehash() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPasswordsMigratesLegacyHashes(t *testing.T) {
+	legacy := NewBcrypt(bcrypt.MinCost)
+	ps := NewPasswords(NewArgon2id(testArgon2Params), legacy)
+	old, err := legacy.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := ps.Verify("correct horse", old); !ok || err != nil {
+		t.Errorf("Verify() of a legacy hash = %v, %v", ok, err)
+	}
+	if !ps.NeedsRehash(old) {
+		t.Errorf("NeedsRehash() of a legacy hash = false")
+	}
+
+	encoded, err := ps.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, argon2Prefix) {
+		t.Errorf("Hash() = %q, want an argon2id hash", encoded)
+	}
+	if ps.NeedsRehash(encoded) {
+		t.Errorf("NeedsRehash() of a primary hash = true")
+	}
+
+	unknown := "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA"
+	if ps.Identifies(unknown) {
+		t.Errorf("Identifies(%q) = true", unknown)
+	}
+	if _, err := ps.Verify("correct horse", unknown); err != ErrUnknownHash {
+		t.Errorf("Verify() of an unknown hash err = %v, want ErrUnknownHash", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Package imaging decodes, turns upright and resizes photos
+// using only the standard library's image packages.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// ErrTooLarge is returned by Decode for images with more
+// pixels than it was allowed to decode
+var ErrTooLarge = errors.New("imaging: image has too many pixels")
+
+// Decode reads a JPEG, PNG or GIF image and turns it upright,
+// following the EXIF orientation of JPEGs. Only the first frame
+// of animated GIFs is read. Images with more than maxPixels
+// pixels are rejected with ErrTooLarge before they are decoded,
+// since decoding them could take far more memory than the file
+// itself.
+func Decode(r io.Reader, maxPixels int) (*image.RGBA, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxPixels/cfg.Height {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return Orient(img, Orientation(data)), nil
+}
+
+// Encode writes img as a JPEG of the given quality, or as a
+// PNG when img has transparent pixels that JPEG would lose. It
+// returns the content type of what it wrote.
+func Encode(w io.Writer, img *image.RGBA, quality int) (string, error) {
+	if img.Opaque() {
+		return "image/jpeg", jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	return "image/png", png.Encode(w, img)
+}
+
+// toRGBA returns img as an *image.RGBA whose bounds start at
+// (0, 0), copying it unless it already is one
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,131 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// Orientation returns the EXIF orientation stored in a JPEG,
+// from 1 to 8, as defined by the TIFF spec. 1 means the image
+// is already upright, and is returned for anything that isn't
+// a JPEG or has no valid orientation tag.
+func Orientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	data = data[2:]
+	for len(data) >= 4 {
+		if data[0] != 0xFF {
+			return 1
+		}
+		marker := data[1]
+		switch {
+		case marker == 0xFF:
+			// fill byte before a marker
+			data = data[1:]
+			continue
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+			// markers without a length
+			data = data[2:]
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// the image data starts, and EXIF always comes
+			// before it
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < 2 || len(data) < 2+length {
+			return 1
+		}
+		segment := data[4 : 2+length]
+		if marker == 0xE1 && len(segment) >= 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		data = data[2+length:]
+	}
+	return 1
+}
+
+// exifOrientation looks for the orientation tag in the first
+// IFD of the TIFF structure EXIF data is kept in
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		const tagOrientation, typeShort = 0x0112, 3
+		if order.Uint16(tiff[entry:entry+2]) != tagOrientation {
+			continue
+		}
+		if order.Uint16(tiff[entry+2:entry+4]) != typeShort {
+			return 1
+		}
+		o := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if o < 1 || o > 8 {
+			return 1
+		}
+		return o
+	}
+	return 1
+}
+
+// Orient turns img upright, given its EXIF orientation.
+// Orientations 5 to 8 swap the width and height.
+func Orient(img image.Image, orientation int) *image.RGBA {
+	src := toRGBA(img)
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	// source returns the pixel of src that ends up at (x, y)
+	var source func(x, y int) (int, int)
+	switch orientation {
+	case 2: // mirrored
+		source = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // upside down
+		source = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // upside down and mirrored
+		source = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // transposed
+		source = func(x, y int) (int, int) { return y, x }
+	case 6: // needs turning clockwise
+		source = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // transversed
+		source = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // needs turning counterclockwise
+		source = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,122 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// FitSize returns the largest size with the same aspect ratio
+// as width x height that fits within maxWidth x maxHeight.
+// Images are never enlarged, so a size that already fits is
+// returned as it is.
+func FitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+	scale := math.Min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	w := int(math.Round(float64(width) * scale))
+	h := int(math.Round(float64(height) * scale))
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// Fit shrinks img to fit within maxWidth x maxHeight, keeping
+// its aspect ratio. See FitSize.
+func Fit(img *image.RGBA, maxWidth, maxHeight int) *image.RGBA {
+	w, h := FitSize(img.Rect.Dx(), img.Rect.Dy(), maxWidth, maxHeight)
+	return Resize(img, w, h)
+}
+
+// Resize scales img down to width x height. Every pixel of the
+// result is the average of the pixels it covers, which keeps
+// fine detail from turning into noise when photos are shrunk a
+// lot. Resize isn't meant for enlarging images; it returns img
+// unchanged if either dimension would grow.
+func Resize(img *image.RGBA, width, height int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if width > sw || height > sh || width == sw && height == sh {
+		return src
+	}
+	// scale the rows first, then the columns of the result.
+	// RGBA is premultiplied, so averaging is correct even for
+	// transparent pixels.
+	cols := coverage(sw, width)
+	rows := coverage(sh, height)
+	tmp := make([]float32, width*sh*4)
+	for y := 0; y < sh; y++ {
+		line := src.Pix[y*src.Stride:]
+		for x, c := range cols {
+			var r, g, b, a float32
+			for i, weight := range c.weights {
+				p := line[(c.start+i)*4:]
+				r += float32(p[0]) * weight
+				g += float32(p[1]) * weight
+				b += float32(p[2]) * weight
+				a += float32(p[3]) * weight
+			}
+			t := tmp[(y*width+x)*4:]
+			t[0], t[1], t[2], t[3] = r, g, b, a
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, c := range rows {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float32
+			for i, weight := range c.weights {
+				t := tmp[((c.start+i)*width+x)*4:]
+				r += t[0] * weight
+				g += t[1] * weight
+				b += t[2] * weight
+				a += t[3] * weight
+			}
+			p := dst.Pix[dst.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = clamp(r), clamp(g), clamp(b), clamp(a)
+		}
+	}
+	return dst
+}
+
+// span is the run of source pixels that make up one pixel of
+// the result, with how much each of them counts
+type span struct {
+	start   int
+	weights []float32
+}
+
+// coverage works out the spans that shrink n pixels into m
+func coverage(n, m int) []span {
+	scale := float64(n) / float64(m)
+	spans := make([]span, m)
+	for i := range spans {
+		lo := float64(i) * scale
+		hi := lo + scale
+		start := int(lo)
+		end := int(math.Ceil(hi))
+		if end > n {
+			end = n
+		}
+		weights := make([]float32, end-start)
+		for j := start; j < end; j++ {
+			covered := math.Min(hi, float64(j+1)) - math.Max(lo, float64(j))
+			weights[j-start] = float32(covered / scale)
+		}
+		spans[i] = span{start: start, weights: weights}
+	}
+	return spans
+}
+
+func clamp(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
@@ -0,0 +1,141 @@
+// Package jobs runs work in the background, outside of the
+// requests that asked for it. Jobs are kept in a Store, so they
+// survive restarts, and are run by a pool of workers that retry
+// failed jobs with backoff until they run out of attempts.
+package jobs
+
+import (
+	"errors"
+	"time"
+)
+
+// Status is where a job is in its life. Jobs that succeed are
+// deleted, so there is no status for them.
+type Status string
+
+const (
+	// StatusPending jobs run once RunAt has passed
+	StatusPending Status = "pending"
+	// StatusRunning jobs have been claimed by a worker until
+	// LockedUntil. If the worker dies the job is claimed again
+	// after that.
+	StatusRunning Status = "running"
+	// StatusDead jobs failed permanently, or ran out of
+	// attempts. They are kept, along with their last error, so
+	// they can be looked into, and are never run again. The
+	// payloads of Sensitive jobs are wiped.
+	StatusDead Status = "dead"
+)
+
+// Job is a unit of work, stored along with the payload it runs
+// with. Type picks the handler that runs it.
+type Job struct {
+	ID      uint   `gorm:"primary_key"`
+	Type    string `gorm:"not null"`
+	Payload string `gorm:"type:text;not null"`
+	Status  Status `gorm:"not null;index"`
+	// Key is set by Unique. No two stored jobs have the same
+	// key, and it is cleared when a job dies.
+	Key *string `gorm:"unique_index"`
+	// Attempts counts how many times the job has been claimed
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time `gorm:"index"`
+	LockedUntil *time.Time
+	LastError   string `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Payload is the data a job runs with. It is stored as JSON,
+// so only its exported fields are kept. JobType names the
+// handler for it and should never change once jobs have been
+// stored, eg "email.send".
+type Payload interface {
+	JobType() string
+}
+
+// Sensitive is implemented by payloads that hold secrets, such
+// as emails with password reset links. Jobs that succeed are
+// deleted, and the payloads of sensitive jobs that die are
+// wiped, so the secrets aren't kept for as long as dead jobs
+// are.
+type Sensitive interface {
+	Payload
+	Sensitive()
+}
+
+// ErrDuplicate is returned by Store.Create when a job with the
+// same Key is already stored
+var ErrDuplicate = errors.New("jobs: a job with that key is already stored")
+
+// Store keeps jobs until they have run
+type Store interface {
+	// Create stores a new job, setting its ID. It returns
+	// ErrDuplicate if the job has a Key and another stored job
+	// has the same one.
+	Create(job *Job) error
+	// Claim picks a job of one of the types that is due at now:
+	// a pending job whose RunAt has passed, or a running job
+	// whose lock has expired. It marks the job as running and
+	// locked for lease, counts the attempt, and returns it. If
+	// no job is due Claim returns nil, nil. A job is only ever
+	// claimed by one caller at a time.
+	Claim(types []string, now time.Time, lease time.Duration) (*Job, error)
+	// Update saves a job after an attempt failed
+	Update(job *Job) error
+	// Delete removes a job once it has succeeded
+	Delete(job *Job) error
+}
+
+// EnqueueOption changes how a job is run
+type EnqueueOption func(*Job)
+
+// RunAt delays a job until t
+func RunAt(t time.Time) EnqueueOption {
+	return func(j *Job) {
+		j.RunAt = t
+	}
+}
+
+// MaxAttempts overrides how many times a job is tried before
+// it is given up on
+func MaxAttempts(n int) EnqueueOption {
+	return func(j *Job) {
+		j.MaxAttempts = n
+	}
+}
+
+// Unique only enqueues a job if no job with the same key is
+// stored, ie waiting to run, running or waiting to be retried.
+// It is how several processes can schedule the same periodic
+// job without it piling up.
+func Unique(key string) EnqueueOption {
+	return func(j *Job) {
+		j.Key = &key
+	}
+}
+
+// Permanent marks err as one that retrying won't fix, so a
+// handler that returns it sends the job straight to
+// StatusDead
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (pe *permanentError) Error() string {
+	return pe.err.Error()
+}
+
+func (pe *permanentError) Unwrap() error {
+	return pe.err
+}
+
+func isPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
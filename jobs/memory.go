@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps jobs in memory. It is meant for tests,
+// where it lets code enqueue work without a database, and
+// without anything running it unless the test wants to.
+type MemoryStore struct {
+	mu     sync.Mutex
+	nextID uint
+	jobs   map[uint]Job
+}
+
+var _ Store = &MemoryStore{}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs: make(map[uint]Job),
+	}
+}
+
+func (ms *MemoryStore) Create(job *Job) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if job.Key != nil {
+		for _, other := range ms.jobs {
+			if other.Key != nil && *other.Key == *job.Key {
+				return ErrDuplicate
+			}
+		}
+	}
+	ms.nextID++
+	now := time.Now()
+	job.ID = ms.nextID
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	ms.jobs[job.ID] = *job
+	return nil
+}
+
+func (ms *MemoryStore) Claim(types []string, now time.Time, lease time.Duration) (*Job, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	wanted := make(map[string]bool)
+	for _, t := range types {
+		wanted[t] = true
+	}
+	var next *Job
+	for _, job := range ms.jobs {
+		if !wanted[job.Type] || !due(&job, now) {
+			continue
+		}
+		if next == nil || job.RunAt.Before(next.RunAt) ||
+			job.RunAt.Equal(next.RunAt) && job.ID < next.ID {
+			job := job
+			next = &job
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	lockedUntil := now.Add(lease)
+	next.Status = StatusRunning
+	next.LockedUntil = &lockedUntil
+	next.Attempts++
+	next.UpdatedAt = now
+	ms.jobs[next.ID] = *next
+	return next, nil
+}
+
+// due reports whether Claim can pick the job at now
+func due(job *Job, now time.Time) bool {
+	switch job.Status {
+	case StatusPending:
+		return !job.RunAt.After(now)
+	case StatusRunning:
+		return job.LockedUntil != nil && !job.LockedUntil.After(now)
+	}
+	return false
+}
+
+func (ms *MemoryStore) Update(job *Job) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	job.UpdatedAt = time.Now()
+	ms.jobs[job.ID] = *job
+	return nil
+}
+
+func (ms *MemoryStore) Delete(job *Job) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.jobs, job.ID)
+	return nil
+}
+
+// Jobs returns a copy of every job that is stored, in the
+// order they were created
+func (ms *MemoryStore) Jobs() []Job {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ret := make([]Job, 0, len(ms.jobs))
+	for _, job := range ms.jobs {
+		ret = append(ret, job)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
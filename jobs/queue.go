@@ -0,0 +1,338 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Config controls how a Queue runs jobs. Zero values are
+// replaced with the defaults.
+type Config struct {
+	// Workers is how many jobs run at once. It defaults to 4.
+	Workers int
+	// MaxAttempts is how many times a job is tried before it
+	// is marked dead, unless it was enqueued with its own
+	// limit. It defaults to 10.
+	MaxAttempts int
+	// Timeout is how long a single attempt can take. It
+	// defaults to 5 minutes.
+	Timeout time.Duration
+	// PollInterval is how often idle workers look for jobs
+	// enqueued by other processes, or that have become due. It
+	// defaults to 5 seconds.
+	PollInterval time.Duration
+	// Backoff is how long to wait before retrying a job that
+	// has failed attempts times. It defaults to
+	// ExponentialBackoff.
+	Backoff func(attempts int) time.Duration
+}
+
+// ExponentialBackoff waits 10 seconds after the first failed
+// attempt and doubles that after every other, up to an hour,
+// with up to 20% of jitter so failed jobs don't all come back
+// at once
+func ExponentialBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	d := time.Hour
+	if attempts < 10 {
+		d = 10 * time.Second << uint(attempts-1)
+		if d > time.Hour {
+			d = time.Hour
+		}
+	}
+	return d + time.Duration(rand.Int63n(int64(d/5)+1))
+}
+
+// leaseMargin is how much longer than Timeout a job stays
+// locked, so it isn't claimed again while its attempt is
+// still wrapping up
+const leaseMargin = time.Minute
+
+// Queue enqueues jobs in a Store and runs them with the
+// handlers registered with Handle.
+type Queue struct {
+	store    Store
+	cfg      Config
+	handlers map[string]handler
+	types    []string
+	// wake tells an idle worker that a job was just enqueued
+	wake chan struct{}
+	// stop is closed when Shutdown is called, after which
+	// workers don't claim any more jobs
+	stop     chan struct{}
+	stopOnce sync.Once
+	// ctx is canceled if running jobs don't finish before the
+	// deadline passed to Shutdown
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+type handler struct {
+	payload   reflect.Type
+	fn        reflect.Value
+	sensitive bool
+}
+
+// NewQueue returns a Queue for the jobs in store. Nothing runs
+// until Start is called, so a Queue that is never started only
+// enqueues jobs.
+func NewQueue(store Store, cfg Config) *Queue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	if cfg.Backoff == nil {
+		cfg.Backoff = ExponentialBackoff
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		store:    store,
+		cfg:      cfg,
+		handlers: make(map[string]handler),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+var (
+	contextType   = reflect.TypeOf((*context.Context)(nil)).Elem()
+	payloadType   = reflect.TypeOf((*Payload)(nil)).Elem()
+	sensitiveType = reflect.TypeOf((*Sensitive)(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Handle registers fn to run the jobs of one type. fn has to
+// be a func(context.Context, P) error, where P is the Payload
+// type it runs, eg
+//
+//	q.Handle(func(ctx context.Context, job ResizeJob) error {...})
+//
+// The context is canceled when the attempt times out, or when
+// the queue is shut down before the job is done. Handle panics
+// if fn has the wrong type, and has to be called before Start.
+func (q *Queue) Handle(fn interface{}) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 1 ||
+		t.In(0) != contextType || !t.In(1).Implements(payloadType) || t.Out(0) != errorType {
+		panic(fmt.Sprintf("jobs: handler must be a func(context.Context, Payload) error, not %s", t))
+	}
+	payload := t.In(1)
+	zero := reflect.Zero(payload)
+	if payload.Kind() == reflect.Ptr {
+		zero = reflect.New(payload.Elem())
+	}
+	jobType := zero.Interface().(Payload).JobType()
+	if _, ok := q.handlers[jobType]; ok {
+		panic(fmt.Sprintf("jobs: %q already has a handler", jobType))
+	}
+	q.handlers[jobType] = handler{
+		payload:   payload,
+		fn:        v,
+		sensitive: payload.Implements(sensitiveType),
+	}
+	q.types = append(q.types, jobType)
+}
+
+// Enqueue stores a job to run p. It returns as soon as the job
+// is stored; the job itself runs later, in whichever process
+// has a handler for it. A job enqueued with Unique while
+// another job with its key is stored is dropped, and Enqueue
+// returns nil.
+func (q *Queue) Enqueue(p Payload, opts ...EnqueueOption) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	job := Job{
+		Type:        p.JobType(),
+		Payload:     string(data),
+		Status:      StatusPending,
+		MaxAttempts: q.cfg.MaxAttempts,
+		RunAt:       time.Now(),
+	}
+	for _, opt := range opts {
+		opt(&job)
+	}
+	switch err := q.store.Create(&job); err {
+	case nil:
+	case ErrDuplicate:
+		return nil
+	default:
+		return err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start starts the workers, which run jobs until Shutdown
+func (q *Queue) Start() {
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+}
+
+// Shutdown stops the workers from starting any more jobs, and
+// waits for the running ones to finish. If ctx is done first
+// the running jobs are canceled, and put back to run again
+// later, before Shutdown returns ctx's error.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.stopOnce.Do(func() {
+		close(q.stop)
+	})
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// RunDue runs the jobs that are due right now, one at a time,
+// and returns how many ran, whether they succeeded or not. It
+// is meant for tests and commands that can't wait for the
+// workers.
+func (q *Queue) RunDue() (int, error) {
+	n := 0
+	for {
+		job, err := q.store.Claim(q.types, time.Now(), q.cfg.Timeout+leaseMargin)
+		if err != nil || job == nil {
+			return n, err
+		}
+		q.run(job)
+		n++
+	}
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+		job, err := q.store.Claim(q.types, time.Now(), q.cfg.Timeout+leaseMargin)
+		if err != nil {
+			log.Println(err)
+		}
+		if job != nil {
+			q.run(job)
+			continue
+		}
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-time.After(q.cfg.PollInterval):
+		}
+	}
+}
+
+// run runs a claimed job and records how it went
+func (q *Queue) run(job *Job) {
+	if job.Attempts > job.MaxAttempts {
+		// the process running the last attempt died with it
+		q.fail(job, errors.New("jobs: ran out of attempts without finishing"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(q.ctx, q.cfg.Timeout)
+	err := q.call(ctx, job)
+	cancel()
+	if err == nil {
+		if err := q.store.Delete(job); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	if q.ctx.Err() != nil {
+		// cut short by Shutdown, which isn't the job's fault
+		job.Attempts--
+		job.Status = StatusPending
+		job.RunAt = time.Now()
+		job.LockedUntil = nil
+		job.LastError = err.Error()
+		if err := q.store.Update(job); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	q.fail(job, err)
+}
+
+// fail retries the job after a backoff, or marks it dead if
+// that was its last attempt or err is permanent
+func (q *Queue) fail(job *Job, err error) {
+	log.Printf("jobs: %s job %d failed attempt %d: %v\n", job.Type, job.ID, job.Attempts, err)
+	job.LastError = err.Error()
+	job.LockedUntil = nil
+	if isPermanent(err) || job.Attempts >= job.MaxAttempts {
+		job.Status = StatusDead
+		// dead jobs are kept, but shouldn't stop the same
+		// unique job from being enqueued again
+		job.Key = nil
+		if q.handlers[job.Type].sensitive {
+			job.Payload = ""
+		}
+	} else {
+		job.Status = StatusPending
+		job.RunAt = time.Now().Add(q.cfg.Backoff(job.Attempts))
+	}
+	if err := q.store.Update(job); err != nil {
+		log.Println(err)
+	}
+}
+
+// call decodes the job's payload and passes it to its handler.
+// A handler that panics fails the attempt instead of taking
+// the worker down.
+func (q *Queue) call(ctx context.Context, job *Job) (err error) {
+	h, ok := q.handlers[job.Type]
+	if !ok {
+		return Permanent(fmt.Errorf("jobs: no handler for %q", job.Type))
+	}
+	payload := reflect.New(h.payload)
+	if err := json.Unmarshal([]byte(job.Payload), payload.Interface()); err != nil {
+		return Permanent(err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: handler panicked: %v", r)
+		}
+	}()
+	out := h.fn.Call([]reflect.Value{reflect.ValueOf(ctx), payload.Elem()})
+	err, _ = out[0].Interface().(error)
+	return err
+}
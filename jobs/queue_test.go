@@ -0,0 +1,346 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testJob struct {
+	Name string
+}
+
+func (testJob) JobType() string {
+	return "test.job"
+}
+
+type secretJob struct {
+	Token string
+}
+
+func (secretJob) JobType() string {
+	return "test.secret"
+}
+
+func (secretJob) Sensitive() {}
+
+var errTest = errors.New("something went wrong")
+
+// newTestQueue returns a queue with a handler for testJob that
+// returns the errors in results, in order, and then nil
+func newTestQueue(cfg Config, results ...error) (*Queue, *MemoryStore, *[]string) {
+	store := NewMemoryStore()
+	q := NewQueue(store, cfg)
+	var mu sync.Mutex
+	var ran []string
+	q.Handle(func(ctx context.Context, job testJob) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, job.Name)
+		if len(results) == 0 {
+			return nil
+		}
+		err := results[0]
+		results = results[1:]
+		return err
+	})
+	return q, store, &ran
+}
+
+func TestRunDue(t *testing.T) {
+	q, store, ran := newTestQueue(Config{})
+	for _, name := range []string{"a", "b"} {
+		if err := q.Enqueue(testJob{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Enqueue(testJob{Name: "later"}, RunAt(time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	n, err := q.RunDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || strings.Join(*ran, ",") != "a,b" {
+		t.Errorf("RunDue() ran %d jobs: %v, want a,b", n, *ran)
+	}
+	jobs := store.Jobs()
+	if len(jobs) != 1 || jobs[0].Status != StatusPending {
+		t.Errorf("jobs left = %+v, want only the one that isn't due", jobs)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	var backoffs []int
+	cfg := Config{
+		Backoff: func(attempts int) time.Duration {
+			backoffs = append(backoffs, attempts)
+			return time.Hour
+		},
+	}
+	q, store, _ := newTestQueue(cfg, errTest, errTest)
+	if err := q.Enqueue(testJob{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	if n, _ := q.RunDue(); n != 1 {
+		t.Fatalf("RunDue() ran %d jobs, want 1", n)
+	}
+	job := store.Jobs()[0]
+	if job.Status != StatusPending || job.Attempts != 1 || job.LastError != errTest.Error() {
+		t.Errorf("after a failure job = %+v, want pending with 1 attempt and the error", job)
+	}
+	if job.RunAt.Before(before.Add(time.Hour)) {
+		t.Errorf("job runs again at %v, want an hour later", job.RunAt)
+	}
+	if job.LockedUntil != nil {
+		t.Errorf("failed job is still locked")
+	}
+	// not due again until the backoff is over
+	if n, _ := q.RunDue(); n != 0 {
+		t.Errorf("RunDue() ran %d jobs during the backoff, want 0", n)
+	}
+
+	makeDue(store)
+	q.RunDue()
+	makeDue(store)
+	q.RunDue()
+	if jobs := store.Jobs(); len(jobs) != 0 {
+		t.Errorf("jobs left after success = %+v, want none", jobs)
+	}
+	if len(backoffs) != 2 || backoffs[0] != 1 || backoffs[1] != 2 {
+		t.Errorf("Backoff called with %v, want [1 2]", backoffs)
+	}
+}
+
+// makeDue skips the backoff of every stored job
+func makeDue(store *MemoryStore) {
+	for _, job := range store.Jobs() {
+		job.RunAt = time.Now()
+		store.Update(&job)
+	}
+}
+
+func TestUnique(t *testing.T) {
+	q, store, ran := newTestQueue(Config{MaxAttempts: 1}, errTest)
+	for _, name := range []string{"a", "b"} {
+		if err := q.Enqueue(testJob{Name: name}, Unique("k")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(store.Jobs()); got != 1 {
+		t.Fatalf("%d jobs stored, want the second unique job dropped", got)
+	}
+	// the job dies, which frees its key
+	q.RunDue()
+	if err := q.Enqueue(testJob{Name: "c"}, Unique("k")); err != nil {
+		t.Fatal(err)
+	}
+	// and once it succeeds it is deleted, freeing it again
+	q.RunDue()
+	if err := q.Enqueue(testJob{Name: "d"}, Unique("k")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(*ran, ",") != "a,c" {
+		t.Errorf("ran %v, want a,c", *ran)
+	}
+	jobs := store.Jobs()
+	if len(jobs) != 2 || jobs[0].Status != StatusDead || jobs[0].Key != nil || jobs[1].Status != StatusPending {
+		t.Errorf("jobs left = %+v, want dead a without its key and pending d", jobs)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	cfg := Config{MaxAttempts: 3, Backoff: func(int) time.Duration { return 0 }}
+	q, store, ran := newTestQueue(cfg, errTest, errTest, errTest, errTest)
+	if err := q.Enqueue(testJob{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(testJob{Name: "b"}, MaxAttempts(1)); err != nil {
+		t.Fatal(err)
+	}
+	q.RunDue()
+	if len(*ran) != 4 {
+		t.Errorf("handler ran %d times, want 3 attempts of a and 1 of b", len(*ran))
+	}
+	for _, job := range store.Jobs() {
+		if job.Status != StatusDead {
+			t.Errorf("job %d status = %q, want dead", job.ID, job.Status)
+		}
+		if job.Attempts != job.MaxAttempts {
+			t.Errorf("job %d made %d attempts, want %d", job.ID, job.Attempts, job.MaxAttempts)
+		}
+		if job.LastError != errTest.Error() {
+			t.Errorf("job %d LastError = %q", job.ID, job.LastError)
+		}
+		if !strings.Contains(job.Payload, `"Name"`) {
+			t.Errorf("job %d payload = %q, want it kept", job.ID, job.Payload)
+		}
+	}
+	// dead jobs are never run again
+	if n, _ := q.RunDue(); n != 0 {
+		t.Errorf("RunDue() ran %d dead jobs", n)
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	q, store, ran := newTestQueue(Config{Backoff: func(int) time.Duration { return 0 }}, Permanent(errTest))
+	if err := q.Enqueue(testJob{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	q.RunDue()
+	if len(*ran) != 1 {
+		t.Errorf("handler ran %d times, want 1", len(*ran))
+	}
+	job := store.Jobs()[0]
+	if job.Status != StatusDead || job.Attempts != 1 || job.LastError != errTest.Error() {
+		t.Errorf("job = %+v, want dead after 1 attempt with the error", job)
+	}
+	if !errors.Is(Permanent(errTest), errTest) {
+		t.Errorf("Permanent doesn't wrap its error")
+	}
+}
+
+func TestPanicFailsAttempt(t *testing.T) {
+	store := NewMemoryStore()
+	q := NewQueue(store, Config{MaxAttempts: 1})
+	q.Handle(func(ctx context.Context, job testJob) error {
+		panic("boom")
+	})
+	q.Enqueue(testJob{})
+	q.RunDue()
+	job := store.Jobs()[0]
+	if job.Status != StatusDead || !strings.Contains(job.LastError, "boom") {
+		t.Errorf("job = %+v, want dead with the panic as its error", job)
+	}
+}
+
+func TestSensitivePayloadWiped(t *testing.T) {
+	store := NewMemoryStore()
+	q := NewQueue(store, Config{Backoff: func(int) time.Duration { return 0 }})
+	q.Handle(func(ctx context.Context, job secretJob) error {
+		return errTest
+	})
+	q.Enqueue(secretJob{Token: "s3cret"}, MaxAttempts(2))
+	q.RunDue()
+	job := store.Jobs()[0]
+	if job.Status != StatusDead {
+		t.Fatalf("job status = %q, want dead", job.Status)
+	}
+	if strings.Contains(job.Payload, "s3cret") {
+		t.Errorf("dead job kept its secret payload %q", job.Payload)
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	store := NewMemoryStore()
+	q := NewQueue(store, Config{Workers: 2, PollInterval: 10 * time.Millisecond})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	q.Handle(func(ctx context.Context, job testJob) error {
+		close(started)
+		<-release
+		return nil
+	})
+	q.Start()
+	if err := q.Enqueue(testJob{Name: "slow"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	done := make(chan error)
+	go func() {
+		done <- q.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown() returned %v while a job was running", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// jobs enqueued after Shutdown aren't started
+	q.Enqueue(testJob{Name: "after"})
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown() err = %v", err)
+	}
+	jobs := store.Jobs()
+	if len(jobs) != 1 || jobs[0].Status != StatusPending {
+		t.Errorf("jobs left = %+v, want only the one enqueued after Shutdown", jobs)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	store := NewMemoryStore()
+	q := NewQueue(store, Config{Workers: 1})
+	started := make(chan struct{})
+	q.Handle(func(ctx context.Context, job testJob) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	q.Start()
+	q.Enqueue(testJob{Name: "stuck"})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() err = %v, want DeadlineExceeded", err)
+	}
+	// the job is put back, without counting the attempt
+	job := store.Jobs()[0]
+	if job.Status != StatusPending || job.Attempts != 0 || job.LockedUntil != nil {
+		t.Errorf("canceled job = %+v, want pending with no attempts", job)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		min      time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tc := range tests {
+		for i := 0; i < 20; i++ {
+			got := ExponentialBackoff(tc.attempts)
+			if got < tc.min || got > tc.min+tc.min/5 {
+				t.Fatalf("ExponentialBackoff(%d) = %v, want %v plus up to 20%%", tc.attempts, got, tc.min)
+			}
+		}
+	}
+}
+
+func TestHandlePanics(t *testing.T) {
+	tests := map[string]interface{}{
+		"not a func":      "nope",
+		"no context":      func(job testJob) error { return nil },
+		"not a payload":   func(ctx context.Context, s string) error { return nil },
+		"no error":        func(ctx context.Context, job testJob) {},
+		"already handled": func(ctx context.Context, job testJob) error { return nil },
+	}
+	for name, fn := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := NewQueue(NewMemoryStore(), Config{})
+			if name == "already handled" {
+				q.Handle(func(ctx context.Context, job testJob) error { return nil })
+			}
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Handle() didn't panic")
+				}
+			}()
+			q.Handle(fn)
+		})
+	}
+}
@@ -1,34 +1,109 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"lenslocked.com/controllers"
+	"lenslocked.com/email"
+	"lenslocked.com/jobs"
 	"lenslocked.com/middleware"
 	"lenslocked.com/models"
-)
-
-const (
-	host   = "localhost"
-	port   = 5432
-	user   = "godwin"
-	dbname = "lenslockedDb_dev"
+	"lenslocked.com/storage"
 )
 
 func main() {
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable", host, port, user, dbname)
-	services, err := models.NewServices(psqlInfo)
+	boolPtr := flag.Bool("prod", false, "Provide this flag in production. This ensures that a .config file is provided before the application starts.")
+	promote := flag.String("promote", "", "Give the user with this email address the admin role, then exit.")
+	regenerateVariants := flag.Bool("regenerate-variants", false, "Remake the resized variants of every image for the configured sizes, then exit.")
+	flag.Parse()
+
+	cfg := LoadConfig(*boolPtr)
+	hmacKeys := cfg.HMAC.Keyring()
+	blob := cfg.Storage.Blob()
+	services, err := models.NewServices(
+		models.WithGorm("postgres", cfg.Database.ConnectionInfo()),
+		models.WithLogMode(!cfg.IsProd()),
+		models.WithJobs(cfg.Jobs.Queue()),
+		models.WithUser(cfg.Pepper, cfg.Password.Hasher(), hmacKeys),
+		models.WithSession(hmacKeys),
+		models.WithImage(blob, cfg.Images.MaxFileMB<<20, cfg.Images.Presets()),
+		models.WithGallery(),
+		models.WithAudit(),
+		models.WithAPIToken(hmacKeys),
+		models.WithInvitation(hmacKeys),
+	)
 	must(err)
 	defer services.Close()
 	services.AutoMigrate()
 	// services.DestructiveReset()
 
+	if *promote != "" {
+		must(promoteToAdmin(services.User, *promote))
+		return
+	}
+	if *regenerateVariants {
+		must(createVariants(services.Image))
+		return
+	}
+
+	var mailer email.Mailer = &email.LogMailer{W: os.Stdout}
+	if cfg.Mailer.SMTPHost != "" {
+		mailer = &email.SMTPMailer{
+			Host:     cfg.Mailer.SMTPHost,
+			Port:     cfg.Mailer.SMTPPort,
+			Username: cfg.Mailer.SMTPUsername,
+			Password: cfg.Mailer.SMTPPassword,
+		}
+	}
+	// emails are delivered by background jobs, so requests
+	// never wait on the mail server
+	emailer := email.NewClient(
+		email.WithSender(cfg.Mailer.FromName, cfg.Mailer.FromEmail),
+		email.WithBaseURL(cfg.BaseURL),
+		email.WithMailer(email.NewQueueMailer(services.Jobs, mailer)),
+	)
+
+	cookies := cfg.Cookie.Manager()
+
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User)
-	galleriesC := controllers.NewGalleries(services.Gallery)
-	requireUserMw := middleware.RequireUser{UserService: services.User } 
+	errorsC := controllers.NewErrors()
+	usersC := controllers.NewUsers(services.User, services.Session, services.Invitation, cookies, emailer, cfg.LoginMethods(), cfg.InviteOnly)
+	accountC := controllers.NewAccount(services.User, services.Session, services.APIToken, services.Invitation, cookies, emailer)
+	galleriesC := controllers.NewGalleries(services.Gallery, services.Image)
+	adminC := controllers.NewAdmin(services.User, services.Gallery, services.Session, services.Audit, cookies, emailer)
+	userMw := middleware.User{
+		UserService:     services.User,
+		SessionService:  services.Session,
+		APITokenService: services.APIToken,
+		Cookies:         cookies,
+	}
+	requireUserMw := middleware.RequireUser{
+		User: userMw,
+	}
+	requireVerifiedMw := middleware.RequireVerified{}
+	galleriesWriteMw := middleware.RequireScope{
+		RequireUser: requireUserMw,
+		Scope:       models.ScopeGalleriesWrite,
+	}
+	galleriesReadMw := middleware.RequireScope{
+		RequireUser: requireUserMw,
+		Scope:       models.ScopeGalleriesRead,
+	}
+	requireAdminMw := middleware.RequireRole{
+		Role: models.RoleAdmin,
+	}
+	requireAdmin := func(fn http.HandlerFunc) http.HandlerFunc {
+		return requireUserMw.ApplyFn(requireAdminMw.ApplyFn(fn))
+	}
 
 	r := mux.NewRouter()
 
@@ -38,16 +113,156 @@ func main() {
 	r.HandleFunc("/signup", usersC.New).Methods("GET")
 	r.HandleFunc("/signup", usersC.Create).Methods("POST")
 
-	r.Handle("/login", usersC.LoginView).Methods("GET")
+	r.HandleFunc("/login", usersC.LoginPage).Methods("GET")
 	r.HandleFunc("/login", usersC.Login).Methods("POST")
-	r.HandleFunc("/cookie-test", usersC.CookieTest).Methods("GET")
+	r.HandleFunc("/login/magic", usersC.SendMagicLink).Methods("POST")
+	r.HandleFunc("/login/magic", usersC.MagicLink).Methods("GET")
+	r.HandleFunc("/login/magic/confirm", usersC.CompleteMagicLogin).Methods("POST")
+	r.Handle("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST")
+	r.HandleFunc("/forgot", usersC.ForgotPw).Methods("GET")
+	r.HandleFunc("/forgot", usersC.InitiateReset).Methods("POST")
+	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET")
+	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST")
+	r.HandleFunc("/login/2fa", usersC.LoginTwoFactor).Methods("POST")
+	r.HandleFunc("/verify", usersC.Verify).Methods("GET")
+	r.HandleFunc("/verify/resend", requireUserMw.ApplyFn(usersC.ResendVerification)).Methods("POST")
+
+	// account routes
+	r.HandleFunc("/account", requireUserMw.ApplyFn(accountC.Settings)).Methods("GET")
+	r.HandleFunc("/account/profile", requireUserMw.ApplyFn(accountC.UpdateProfile)).Methods("POST")
+	r.HandleFunc("/account/email", requireUserMw.ApplyFn(accountC.UpdateEmail)).Methods("POST")
+	r.HandleFunc("/account/password", requireUserMw.ApplyFn(accountC.UpdatePassword)).Methods("POST")
+	r.HandleFunc("/account/delete", requireUserMw.ApplyFn(accountC.ConfirmDelete)).Methods("GET")
+	r.HandleFunc("/account/delete", requireUserMw.ApplyFn(accountC.Delete)).Methods("POST")
+	r.HandleFunc("/account/restore", requireUserMw.ApplyFn(accountC.ConfirmRestore)).Methods("GET")
+	r.HandleFunc("/account/restore", requireUserMw.ApplyFn(accountC.Restore)).Methods("POST")
+	r.HandleFunc("/account/sessions", requireUserMw.ApplyFn(accountC.Sessions)).Methods("GET")
+	r.HandleFunc("/account/sessions/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(accountC.RevokeSession)).Methods("POST")
+	r.HandleFunc("/account/2fa", requireUserMw.ApplyFn(accountC.TwoFactor)).Methods("GET")
+	r.HandleFunc("/account/2fa", requireUserMw.ApplyFn(accountC.EnableTwoFactor)).Methods("POST")
+	r.HandleFunc("/account/2fa/disable", requireUserMw.ApplyFn(accountC.DisableTwoFactor)).Methods("POST")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(accountC.Tokens)).Methods("GET")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(accountC.CreateToken)).Methods("POST")
+	r.HandleFunc("/account/tokens/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(accountC.RevokeToken)).Methods("POST")
+	r.HandleFunc("/account/invitations", requireUserMw.ApplyFn(accountC.Invitations)).Methods("GET")
+	r.HandleFunc("/account/invitations", requireUserMw.ApplyFn(accountC.CreateInvitation)).Methods("POST")
+	r.HandleFunc("/account/invitations/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(accountC.RevokeInvitation)).Methods("POST")
 
 	// gallery routes
-	r.Handle("/galleries/new", requireUserMw.Apply(galleriesC.New)).Methods("GET")
-	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Create)).Methods("POST")
- 
-	fmt.Println("Server running on :3000....")
-	http.ListenAndServe(":3000", r)
+	r.Handle("/galleries/new", requireUserMw.Apply(requireVerifiedMw.Apply(galleriesC.New))).Methods("GET")
+	r.HandleFunc("/galleries", galleriesWriteMw.ApplyFn(requireVerifiedMw.ApplyFn(galleriesC.Create))).Methods("POST")
+	r.HandleFunc("/galleries", galleriesReadMw.ApplyFn(galleriesC.Index)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/update", galleriesWriteMw.ApplyFn(galleriesC.Update)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/delete", galleriesWriteMw.ApplyFn(galleriesC.Delete)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images", galleriesWriteMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", galleriesWriteMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST")
+
+	// image routes
+	imageHandler := http.StripPrefix("/images/", storage.FileServer(blob))
+	r.PathPrefix("/images/").Handler(imageHandler).Methods("GET", "HEAD")
+
+	// admin routes
+	r.HandleFunc("/admin", requireAdmin(adminC.Index)).Methods("GET")
+	r.HandleFunc("/admin/users", requireAdmin(adminC.Users)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}", requireAdmin(adminC.User)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/disable", requireAdmin(adminC.DisableUser)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/enable", requireAdmin(adminC.EnableUser)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/reset-password", requireAdmin(adminC.ResetPassword)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/delete", requireAdmin(adminC.DeleteUser)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/impersonate", requireAdmin(adminC.Impersonate)).Methods("POST")
+	r.HandleFunc("/admin/galleries", requireAdmin(adminC.Galleries)).Methods("GET")
+	r.HandleFunc("/admin/galleries/{id:[0-9]+}/delete", requireAdmin(adminC.DeleteGallery)).Methods("POST")
+	r.HandleFunc("/admin/audit", requireAdmin(adminC.Audit)).Methods("GET")
+	r.HandleFunc("/impersonate/stop", requireUserMw.ApplyFn(adminC.StopImpersonating)).Methods("POST")
+
+	services.Jobs.Start()
+	stopPurge := make(chan struct{})
+	go purgeDeletedUsers(services.Jobs, stopPurge)
+
+	csrfMw := middleware.NewCSRF([]byte(cfg.CSRFKey), cfg.Cookie.Secure, http.HandlerFunc(errorsC.CSRF))
+	maxBytesMw := middleware.MaxBytes{Limit: cfg.Images.MaxRequestMB << 20}
+	server := http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: maxBytesMw.Apply(csrfMw.Apply(userMw.Apply(r))),
+	}
+	go func() {
+		fmt.Printf("Server running on :%d....\n", cfg.Port)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// on Ctrl+C or SIGTERM, finish the requests and jobs that
+	// are running before exiting
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	fmt.Println("Shutting down....")
+	close(stopPurge)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Jobs.ShutdownSeconds)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+	if err := services.Jobs.Shutdown(ctx); err != nil {
+		log.Println("Jobs were still running:", err)
+	}
+}
+
+// promoteToAdmin gives the user with the provided email the
+// admin role. It's how the first admin of a site is created.
+func promoteToAdmin(us models.UserService, email string) error {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return err
+	}
+	user.Role = models.RoleAdmin
+	if err := us.Update(user); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now an admin\n", user.Email)
+	return nil
+}
+
+// createVariants remakes the variants of every image, so they
+// match the sizes that are configured now. Images that fail are
+// logged and skipped.
+func createVariants(is models.ImageService) error {
+	images, err := is.All()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i := range images {
+		if err := is.CreateVariants(&images[i]); err != nil {
+			log.Printf("%s: %v\n", images[i].Path(), err)
+			failed++
+		}
+	}
+	fmt.Printf("Made variants of %d images, %d failed\n", len(images)-failed, failed)
+	return nil
+}
+
+// purgeDeletedUsers enqueues a job every hour, until stop is
+// closed, that removes the accounts whose deletion grace period
+// is over. The job is unique, so however many processes are
+// running only one of them is ever waiting to run.
+func purgeDeletedUsers(queue *jobs.Queue, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		err := queue.Enqueue(models.PurgeDeletedUsersJob{}, jobs.Unique(models.PurgeDeletedUsersJob{}.JobType()))
+		if err != nil {
+			log.Println(err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
 }
 
 func must(err error) {
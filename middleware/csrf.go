@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/csrf"
+)
+
+// CSRF rejects state changing requests that don't carry the
+// token views render into our forms. Requests made with a
+// personal access token skip the check, as browsers never
+// attach an Authorization header on their own.
+type CSRF struct {
+	protect func(http.Handler) http.Handler
+}
+
+// NewCSRF returns the CSRF middleware. key has to be 32 bytes
+// and secure should be set whenever the site is served over
+// HTTPS. Rejected requests are handed to failure.
+func NewCSRF(key []byte, secure bool, failure http.Handler) *CSRF {
+	return &CSRF{
+		protect: csrf.Protect(key,
+			csrf.Secure(secure),
+			csrf.Path("/"),
+			csrf.HttpOnly(true),
+			csrf.ErrorHandler(failure)),
+	}
+}
+
+func (mw *CSRF) Apply(next http.Handler) http.HandlerFunc {
+	protected := mw.protect(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerToken(r); ok {
+			r = csrf.UnsafeSkipCheck(r)
+		}
+		protected.ServeHTTP(w, r)
+	})
+}
+
+func (mw *CSRF) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.Apply(next)
+}
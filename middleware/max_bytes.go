@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// MaxBytes caps the size of request bodies at Limit bytes. It
+// has to wrap the CSRF middleware, which reads form bodies
+// looking for the token before any of our handlers run.
+type MaxBytes struct {
+	Limit int64
+}
+
+func (mw *MaxBytes) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *MaxBytes) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > mw.Limit {
+			http.Error(w, "The request is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		// bodies without a Content-Length are cut off instead
+		r.Body = http.MaxBytesReader(w, r.Body, mw.Limit)
+		next(w, r)
+	})
+}
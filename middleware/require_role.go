@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+
+	"lenslocked.com/context"
+)
+
+// RequireRole only lets users with Role through. It expects
+// to be applied inside RequireUser, eg
+//
+//	requireUserMw.Apply(requireAdminMw.Apply(handler))
+//
+// Logged in users without the role get a 403 Forbidden.
+type RequireRole struct {
+	Role string
+}
+
+func (mw *RequireRole) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireRole) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if !user.HasRole(mw.Role) {
+			http.Error(w, "You are not allowed to view this page", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
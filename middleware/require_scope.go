@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"lenslocked.com/context"
+)
+
+// RequireScope is used instead of RequireUser on routes that
+// scripts are allowed to call. Requests made with a personal
+// access token need to have been granted Scope, while everyone
+// else is handled exactly like RequireUser would.
+type RequireScope struct {
+	RequireUser
+	Scope string
+}
+
+func (mw *RequireScope) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireScope) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	requireUser := mw.RequireUser.ApplyFn(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := context.APIToken(r.Context())
+		if token == nil {
+			requireUser(w, r)
+			return
+		}
+		if !token.HasScope(mw.Scope) {
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, mw.Scope))
+			http.Error(w, "The access token doesn't have the "+mw.Scope+" scope", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// bearerToken returns the token from the request's
+// "Authorization: Bearer" header, if it has one
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// unauthorized tells an API client its credentials were
+// not accepted
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
@@ -1,34 +1,153 @@
 package middleware
 
 import (
-	"fmt"
+	"log"
 	"net/http"
+	"time"
 
+	"lenslocked.com/context"
+	"lenslocked.com/cookie"
 	"lenslocked.com/models"
+	"lenslocked.com/netutil"
 )
 
-type RequireUser struct {
+// touchInterval limits how often a session's LastSeenAt, and
+// its sliding expiry, are written back to the database
+const touchInterval = time.Minute
+
+// User middleware will look up the session in the user's
+// remember_token cookie and, if it is valid, attach both the
+// session and its user to the request context. It never
+// redirects, so it is safe to wrap around public pages as well.
+// Sessions belonging to disabled accounts are revoked rather
+// than attached. Sessions in use are renewed through Cookies,
+// so they only expire once they have been left idle.
+//
+// Requests carrying a personal access token in an
+// "Authorization: Bearer" header are resolved through the
+// token instead, and rejected outright if the token is bad.
+type User struct {
 	models.UserService
+	models.SessionService
+	models.APITokenService
+	Cookies *cookie.Manager
 }
 
-func (mw *RequireUser) Apply(next http.Handler) http.HandlerFunc {
+func (mw *User) Apply(next http.Handler) http.HandlerFunc {
 	return mw.ApplyFn(next.ServeHTTP)
 }
 
-func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("remember_token")
+		if token, ok := bearerToken(r); ok {
+			mw.applyToken(w, r, token, next)
+			return
+		}
+		remember, err := r.Cookie(cookie.Session)
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusFound)
+			next(w, r)
 			return
 		}
-		user, err := mw.UserService.ByRemember(cookie.Value)
+		session, err := mw.SessionService.ByRemember(remember.Value)
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusFound)
+			if err == models.ErrNotFound {
+				// the session expired or was revoked
+				mw.Cookies.Clear(w, cookie.Session)
+			}
+			next(w, r)
 			return
 		}
-		fmt.Println("User found", user)
+		user, err := mw.UserService.ByID(session.UserID)
+		if err != nil {
+			next(w, r)
+			return
+		}
+		if user.Disabled() {
+			// Disable already revokes sessions, this catches any
+			// that slipped through while it was running
+			if err := mw.SessionService.Delete(session.ID); err != nil {
+				log.Println(err)
+			}
+			mw.Cookies.Clear(w, cookie.Session)
+			next(w, r)
+			return
+		}
+		if time.Since(session.LastSeenAt) > touchInterval {
+			session.LastSeenAt = time.Now()
+			session.IP = netutil.ClientIP(r)
+			mw.Cookies.Renew(session)
+			if err := mw.SessionService.Update(session); err != nil {
+				log.Println(err)
+			} else if session.Persistent {
+				session.Token = remember.Value
+				mw.Cookies.SetSession(w, session)
+			}
+		}
+		ctx := context.WithUser(r.Context(), user)
+		ctx = context.WithSession(ctx, session)
+		next(w, r.WithContext(ctx))
+	})
+}
+
+// applyToken attaches the owner of a personal access token,
+// and the token itself, to the request context
+func (mw *User) applyToken(w http.ResponseWriter, r *http.Request, token string, next http.HandlerFunc) {
+	apiToken, err := mw.APITokenService.ByToken(token)
+	if err != nil {
+		unauthorized(w, "The access token is invalid, expired or has been revoked")
+		return
+	}
+	user, err := mw.UserService.ByID(apiToken.UserID)
+	if err != nil || user.Disabled() || user.DeletionScheduled() {
+		unauthorized(w, "The access token is invalid, expired or has been revoked")
+		return
+	}
+	if apiToken.LastUsedAt == nil || time.Since(*apiToken.LastUsedAt) > touchInterval {
+		now := time.Now()
+		apiToken.LastUsedAt = &now
+		if err := mw.APITokenService.Update(apiToken); err != nil {
+			log.Println(err)
+		}
+	}
+	ctx := context.WithUser(r.Context(), user)
+	ctx = context.WithAPIToken(ctx, apiToken)
+	next(w, r.WithContext(ctx))
+}
 
+// RequireUser assumes that the User middleware has already
+// been run, otherwise it will always redirect to the login page.
+// Users whose account is scheduled for deletion can only get
+// to the page that restores it, or log out. Requests made with
+// a personal access token are refused; routes scripts may call
+// use RequireScope instead.
+type RequireUser struct {
+	User
+}
+
+var allowedWhileDeleting = map[string]bool{
+	"/account/restore": true,
+	"/logout":          true,
+}
+
+func (mw *RequireUser) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if context.APIToken(r.Context()) != nil {
+			http.Error(w, "This page can't be used with an access token", http.StatusForbidden)
+			return
+		}
+		if user.DeletionScheduled() && !allowedWhileDeleting[r.URL.Path] {
+			http.Redirect(w, r, "/account/restore", http.StatusFound)
+			return
+		}
 		next(w, r)
 	})
 }
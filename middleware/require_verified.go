@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+
+	"lenslocked.com/context"
+	"lenslocked.com/views"
+)
+
+// RequireVerified only lets users who have verified their
+// email address through. Like RequireUser it expects the User
+// middleware to have run already, and it should be applied
+// inside RequireUser so that a user is always present.
+type RequireVerified struct{}
+
+func (mw *RequireVerified) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireVerified) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if !user.Verified() {
+			views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+				Level:   views.AlertLevelWarning,
+				Message: "Please verify your email address before publishing galleries.",
+			})
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AccountDeletionGrace is how long after a user asks for their
+// account to be deleted they can still change their mind. Once
+// it is over the account and everything in it is purged.
+const AccountDeletionGrace = 14 * 24 * time.Hour
+
+// DeletionScheduled reports whether the user has asked for
+// their account to be deleted
+func (u *User) DeletionScheduled() bool {
+	return u.DeletionScheduledAt != nil
+}
+
+// PurgeAt returns when the account will be purged, or the
+// zero time if no deletion is scheduled
+func (u *User) PurgeAt() time.Time {
+	if u.DeletionScheduledAt == nil {
+		return time.Time{}
+	}
+	return u.DeletionScheduledAt.Add(AccountDeletionGrace)
+}
+
+// ScheduleDeletion marks the user's account for deletion once
+// AccountDeletionGrace has passed.
+func (us *userService) ScheduleDeletion(user *User) error {
+	if user.DeletionScheduled() {
+		return nil
+	}
+	now := us.now()
+	user.DeletionScheduledAt = &now
+	return us.Update(user)
+}
+
+// CancelDeletion restores an account that was scheduled for
+// deletion.
+func (us *userService) CancelDeletion(user *User) error {
+	if !user.DeletionScheduled() {
+		return nil
+	}
+	user.DeletionScheduledAt = nil
+	return us.Update(user)
+}
+
+// PurgeDeletedUsers permanently deletes every user whose
+// deletion grace period is over, as well as users that were
+// soft deleted with Delete, along with everything they own.
+// Hard deleting the row also frees up their email address.
+// It returns how many users were purged.
+func (s *Services) PurgeDeletedUsers() (int, error) {
+	cutoff := time.Now().Add(-AccountDeletionGrace)
+	var ids []uint
+	err := s.db.Unscoped().Model(&User{}).
+		Where("deletion_scheduled_at < ? OR deleted_at IS NOT NULL", cutoff).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := s.purgeUser(id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// purgeUser hard deletes a single user and all of their data
+// in one transaction, so a failure never leaves orphans. Image
+// files can't be part of the transaction, so they are deleted
+// first; if anything fails after that, the purge is retried
+// and the remaining rows go then.
+func (s *Services) purgeUser(userID uint) error {
+	if err := s.purgeImages(userID); err != nil {
+		return err
+	}
+	return s.transaction(func(tx *gorm.DB) error {
+		owned := []interface{}{
+			&Gallery{},
+			&Session{},
+			&pwReset{},
+			&recoveryCode{},
+			&APIToken{},
+			&magicLink{},
+		}
+		for _, model := range owned {
+			err := tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Unscoped().Where("created_by_id = ?", userID).Delete(&Invitation{}).Error
+		if err != nil {
+			return err
+		}
+		user := User{Model: gorm.Model{ID: userID}}
+		return tx.Unscoped().Delete(&user).Error
+	})
+}
+
+// purgeImages deletes the images in every gallery the user
+// has, including galleries that were already deleted
+func (s *Services) purgeImages(userID uint) error {
+	if s.Image == nil {
+		return nil
+	}
+	var galleryIDs []uint
+	err := s.db.Unscoped().Model(&Gallery{}).
+		Where("user_id = ?", userID).
+		Pluck("id", &galleryIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range galleryIDs {
+		if err := s.Image.DeleteByGalleryID(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transaction runs fn in a database transaction, which is
+// committed if fn returns nil and rolled back otherwise
+func (s *Services) transaction(fn func(tx *gorm.DB) error) error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
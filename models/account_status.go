@@ -0,0 +1,27 @@
+package models
+
+// Disabled reports whether an admin has disabled the account
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// Disable stops the user from logging in and revokes every
+// session they have, so they are logged out straight away
+// rather than when their cookies expire.
+func (us *userService) Disable(user *User, reason string) error {
+	now := us.now()
+	user.DisabledAt = &now
+	user.DisabledReason = reason
+	if err := us.Update(user); err != nil {
+		return err
+	}
+	return us.sessionDB.DeleteByUserID(user.ID)
+}
+
+// Enable lifts a previous Disable. The user has to log in
+// again, as their sessions are gone.
+func (us *userService) Enable(user *User) error {
+	user.DisabledAt = nil
+	user.DisabledReason = ""
+	return us.Update(user)
+}
@@ -0,0 +1,352 @@
+package models
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+const (
+	// APITokenPrefix starts every personal access token, so
+	// leaked tokens are easy to recognise and scan for
+	APITokenPrefix = "llpat_"
+	// apiTokenBytes is how much randomness each token has
+	apiTokenBytes = 20
+	// apiTokenChecksumLen is the length of the encoded CRC32
+	// at the end of every token
+	apiTokenChecksumLen = 7
+)
+
+// Scopes limit what a personal access token can be used for
+const (
+	ScopeGalleriesRead  = "galleries:read"
+	ScopeGalleriesWrite = "galleries:write"
+)
+
+// APIScopes lists every scope a token can be given, in the
+// order they are offered to users
+var APIScopes = []string{
+	ScopeGalleriesRead,
+	ScopeGalleriesWrite,
+}
+
+// apiTokenEncoding only uses characters that are safe in
+// headers and URLs, and never looks like the "_" separator
+var apiTokenEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// APIToken is a personal access token a user creates to call
+// the site from scripts. Only a digest of the token is stored,
+// so the raw token is shown once, when it is created.
+type APIToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Name      string `gorm:"not null"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+	// Hint is the start of the token, enough for users to
+	// tell their tokens apart without revealing them
+	Hint string
+	// Scopes is a space separated list of scopes
+	Scopes     string
+	LastUsedAt *time.Time
+	// ExpiresAt is nil for tokens that never expire
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+// HasScope reports whether the token was granted scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeList returns the token's scopes as a slice
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// Expired reports whether the token can no longer be used
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// APITokenDB is used to interact with the api_tokens database
+type APITokenDB interface {
+	ByID(id uint) (*APIToken, error)
+	// ByToken looks up an unexpired token by the raw value a
+	// client sent in its Authorization header.
+	ByToken(token string) (*APIToken, error)
+	// ByUserID returns every token of the user, newest first.
+	ByUserID(userID uint) ([]APIToken, error)
+
+	Create(token *APIToken) error
+	Update(token *APIToken) error
+	Delete(id uint) error
+}
+
+// APITokenService is a set of methods used to manipulate and
+// work with personal access tokens
+type APITokenService interface {
+	APITokenDB
+}
+
+func NewAPITokenService(db *gorm.DB, hmac *hash.Keyring) APITokenService {
+	return &apiTokenService{
+		APITokenDB: &apiTokenValidator{
+			APITokenDB: &apiTokenGorm{db},
+			hmac:       hmac,
+		},
+	}
+}
+
+var _ APITokenService = &apiTokenService{}
+
+type apiTokenService struct {
+	APITokenDB
+}
+
+type apiTokenValidatorFunc func(*APIToken) error
+
+func runAPITokenValidatorFunc(token *APIToken, fns ...apiTokenValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ APITokenDB = &apiTokenValidator{}
+
+type apiTokenValidator struct {
+	APITokenDB
+	hmac *hash.Keyring
+}
+
+// ByToken rejects anything that isn't shaped like one of our
+// tokens before going to the database, and then tries the
+// digest under each of our HMAC keys.
+func (atv *apiTokenValidator) ByToken(token string) (*APIToken, error) {
+	if !ValidAPIToken(token) {
+		return nil, ErrNotFound
+	}
+	for _, tokenHash := range atv.hmac.Candidates(token) {
+		found, err := atv.APITokenDB.ByToken(tokenHash)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if found.Expired() {
+			return nil, ErrNotFound
+		}
+		if !atv.hmac.IsPrimary(found.TokenHash) {
+			found.Token = token
+			if err := atv.Update(found); err != nil {
+				return nil, err
+			}
+		}
+		return found, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (atv *apiTokenValidator) Create(token *APIToken) error {
+	err := runAPITokenValidatorFunc(token,
+		atv.userIDRequired,
+		atv.normalizeName,
+		atv.nameRequired,
+		atv.normalizeScopes,
+		atv.scopesValid,
+		atv.generateToken,
+		atv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Create(token)
+}
+
+func (atv *apiTokenValidator) Update(token *APIToken) error {
+	err := runAPITokenValidatorFunc(token,
+		atv.idGreaterThan(0),
+		atv.userIDRequired,
+		atv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Update(token)
+}
+
+func (atv *apiTokenValidator) Delete(id uint) error {
+	var token APIToken
+	token.ID = id
+	err := runAPITokenValidatorFunc(&token, atv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Delete(id)
+}
+
+func (atv *apiTokenValidator) idGreaterThan(n uint) apiTokenValidatorFunc {
+	return apiTokenValidatorFunc(func(t *APIToken) error {
+		if t.ID <= n {
+			return ErrIDInvalid
+		}
+		return nil
+	})
+}
+
+func (atv *apiTokenValidator) userIDRequired(t *APIToken) error {
+	if t.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) normalizeName(t *APIToken) error {
+	t.Name = strings.TrimSpace(t.Name)
+	return nil
+}
+
+func (atv *apiTokenValidator) nameRequired(t *APIToken) error {
+	if t.Name == "" {
+		return ErrNameRequired
+	}
+	return nil
+}
+
+// normalizeScopes removes duplicate scopes and puts them in
+// the same order as APIScopes
+func (atv *apiTokenValidator) normalizeScopes(t *APIToken) error {
+	requested := make(map[string]bool)
+	for _, s := range t.ScopeList() {
+		requested[s] = true
+	}
+	var scopes []string
+	for _, s := range APIScopes {
+		if requested[s] {
+			scopes = append(scopes, s)
+			delete(requested, s)
+		}
+	}
+	if len(requested) > 0 {
+		return ErrScopeInvalid
+	}
+	t.Scopes = strings.Join(scopes, " ")
+	return nil
+}
+
+func (atv *apiTokenValidator) scopesValid(t *APIToken) error {
+	if t.Scopes == "" {
+		return ErrScopeRequired
+	}
+	return nil
+}
+
+// generateToken always replaces the token, as tokens have to
+// come from newAPIToken to carry a valid checksum
+func (atv *apiTokenValidator) generateToken(t *APIToken) error {
+	token, err := newAPIToken()
+	if err != nil {
+		return err
+	}
+	t.Token = token
+	t.Hint = token[:len(APITokenPrefix)+4]
+	return nil
+}
+
+func (atv *apiTokenValidator) hmacToken(t *APIToken) error {
+	if t.Token == "" {
+		return nil
+	}
+	t.TokenHash = atv.hmac.Hash(t.Token)
+	return nil
+}
+
+// newAPIToken returns a random token made of APITokenPrefix,
+// the random part, and a checksum of both
+func newAPIToken() (string, error) {
+	b, err := rand.Bytes(apiTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	body := APITokenPrefix + apiTokenEncoding.EncodeToString(b)
+	return body + apiTokenChecksum(body), nil
+}
+
+func apiTokenChecksum(body string) string {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE([]byte(body)))
+	return apiTokenEncoding.EncodeToString(sum[:])
+}
+
+// ValidAPIToken reports whether token looks like a personal
+// access token we issued, by checking its prefix and checksum.
+// It says nothing about whether the token has been revoked.
+func ValidAPIToken(token string) bool {
+	if !strings.HasPrefix(token, APITokenPrefix) || len(token) <= len(APITokenPrefix)+apiTokenChecksumLen {
+		return false
+	}
+	split := len(token) - apiTokenChecksumLen
+	return apiTokenChecksum(token[:split]) == token[split:]
+}
+
+var _ APITokenDB = &apiTokenGorm{}
+
+type apiTokenGorm struct {
+	db *gorm.DB
+}
+
+func (atg *apiTokenGorm) ByID(id uint) (*APIToken, error) {
+	var token APIToken
+	err := first(atg.db.Where("id = ?", id), &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (atg *apiTokenGorm) ByToken(tokenHash string) (*APIToken, error) {
+	var token APIToken
+	err := first(atg.db.Where("token_hash = ?", tokenHash), &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (atg *apiTokenGorm) ByUserID(userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := atg.db.Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (atg *apiTokenGorm) Create(token *APIToken) error {
+	return atg.db.Create(token).Error
+}
+
+func (atg *apiTokenGorm) Update(token *APIToken) error {
+	return atg.db.Save(token).Error
+}
+
+// Delete permanently removes the token so it can never be
+// used again
+func (atg *apiTokenGorm) Delete(id uint) error {
+	token := APIToken{Model: gorm.Model{ID: id}}
+	return atg.db.Unscoped().Delete(&token).Error
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditUserDisabled        = "user.disabled"
+	AuditUserEnabled         = "user.enabled"
+	AuditUserPasswordReset   = "user.password_reset"
+	AuditUserDeleted         = "user.deleted"
+	AuditGalleryDeleted      = "gallery.deleted"
+	AuditImpersonationStart  = "impersonation.start"
+	AuditImpersonationFinish = "impersonation.finish"
+)
+
+// AuditEntry records something a member of staff did to
+// another user's account or content. Entries are never
+// updated or deleted.
+type AuditEntry struct {
+	gorm.Model
+	// ActorID is the admin who took the action
+	ActorID    uint   `gorm:"not null;index"`
+	Action     string `gorm:"not null;index"`
+	TargetType string
+	TargetID   uint
+	Details    string
+	IP         string
+}
+
+// AuditDB is used to interact with the audit log
+type AuditDB interface {
+	// Recent returns entries newest first
+	Recent(limit, offset int) ([]AuditEntry, error)
+	// ByTarget returns the entries about a single record,
+	// newest first
+	ByTarget(targetType string, targetID uint) ([]AuditEntry, error)
+
+	Create(entry *AuditEntry) error
+}
+
+// AuditService is a set of methods used to write to and
+// read the audit log
+type AuditService interface {
+	AuditDB
+}
+
+func NewAuditService(db *gorm.DB) AuditService {
+	return &auditService{
+		AuditDB: &auditValidator{
+			AuditDB: &auditGorm{db},
+		},
+	}
+}
+
+var _ AuditService = &auditService{}
+
+type auditService struct {
+	AuditDB
+}
+
+type auditValidatorFunc func(*AuditEntry) error
+
+func runAuditValidatorFunc(entry *AuditEntry, fns ...auditValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ AuditDB = &auditValidator{}
+
+type auditValidator struct {
+	AuditDB
+}
+
+func (av *auditValidator) Create(entry *AuditEntry) error {
+	err := runAuditValidatorFunc(entry,
+		av.actorRequired,
+		av.actionRequired)
+	if err != nil {
+		return err
+	}
+	return av.AuditDB.Create(entry)
+}
+
+func (av *auditValidator) actorRequired(entry *AuditEntry) error {
+	if entry.ActorID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (av *auditValidator) actionRequired(entry *AuditEntry) error {
+	if entry.Action == "" {
+		return ErrActionRequired
+	}
+	return nil
+}
+
+var _ AuditDB = &auditGorm{}
+
+type auditGorm struct {
+	db *gorm.DB
+}
+
+func (ag *auditGorm) Recent(limit, offset int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := ag.db.Order("created_at desc").
+		Limit(limit).
+		Offset(offset).
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (ag *auditGorm) ByTarget(targetType string, targetID uint) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := ag.db.Where("target_type = ? AND target_id = ?", targetType, targetID).
+		Order("created_at desc").
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (ag *auditGorm) Create(entry *AuditEntry) error {
+	return ag.db.Create(entry).Error
+}
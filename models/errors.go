@@ -22,15 +22,60 @@ const (
 	ErrPasswordTooShort modelError = "models: password must be atleast 8 characters long"
 	// ErrPasswordRequired is returned when create is attempted without a user password
 	ErrPasswordRequired modelError = "models: password is required"
-	ErrTitleRequired modelError = "models: title is required"
+	ErrTitleRequired    modelError = "models: title is required"
+	// ErrNameRequired is returned when a personal access token isn't given a name
+	ErrNameRequired modelError = "models: name is required"
+	// ErrScopeRequired is returned when a personal access token isn't given any scopes
+	ErrScopeRequired modelError = "models: at least one scope is required"
+	// ErrScopeInvalid is returned when a personal access token is given a scope
+	// that doesn't exist
+	ErrScopeInvalid modelError = "models: scope is not valid"
+	// ErrTokenInvalid is returned when a reset token is unknown,
+	// has already been used or has expired
+	ErrTokenInvalid modelError = "models: token provided is not valid"
+	// ErrTOTPInvalid is returned when a two factor code or recovery code is wrong
+	ErrTOTPInvalid modelError = "models: the authentication code provided is not valid"
+	// ErrTwoFactorEnabled is returned when enrolling a user who already has two
+	// factor authentication turned on
+	ErrTwoFactorEnabled modelError = "models: two factor authentication is already enabled"
+	// ErrTooManyAttempts is returned by Authenticate when an account or
+	// client has failed to log in too many times in a short period
+	ErrTooManyAttempts modelError = "models: too many failed login attempts, please wait a while and try again"
+	// ErrAccountDisabled is returned when a user whose account has been
+	// disabled by an admin tries to log in
+	ErrAccountDisabled modelError = "models: this account has been disabled, please contact support"
+	// ErrInvitationRequired is returned when someone tries to sign up
+	// without an invitation while registration is invite only
+	ErrInvitationRequired modelError = "models: an invitation is required to sign up"
+	// ErrInvitationInvalid is returned when an invitation code is unknown,
+	// has been used up or has expired
+	ErrInvitationInvalid modelError = "models: this invitation is not valid or has expired"
+	// ErrMaxUsesInvalid is returned when an invitation can't be used at all,
+	// or can be used more times than its creator is allowed
+	ErrMaxUsesInvalid modelError = "models: invitation can't be used that many times"
+	// ErrExpiryInvalid is returned when an invitation expires in the past
+	ErrExpiryInvalid modelError = "models: expiry must be in the future"
+	// ErrImageType is returned when an uploaded file isn't a JPEG, PNG or GIF
+	ErrImageType modelError = "models: only JPEG, PNG and GIF images can be uploaded"
+	// ErrImageTooLarge is returned when an uploaded image is over the size limit
+	ErrImageTooLarge modelError = "models: image is too large"
+	// ErrImageCorrupt is returned when an uploaded image can't be decoded
+	ErrImageCorrupt modelError = "models: image is damaged and can't be read"
+	// ErrFilenameInvalid is returned when an image's filename is empty or
+	// tries to leave its gallery's directory
+	ErrFilenameInvalid modelError = "models: filename is not valid"
 	// ErrRememberTooShort is returned when a remember token is not atleast 32 bytes
 	ErrRememberTooShort privateError = "model: remember token must be atleast 32 bytes"
 	// ErrIDInvalid is returned when an invalid ID is provided to a method like delete.
-	ErrIDInvalid privateError  = "models: invalid ID was provided"
+	ErrIDInvalid privateError = "models: invalid ID was provided"
 	// ErrRememberRequired is returned when create or update is attempted without
 	// a valid user remember token hash
-	ErrRememberRequired privateError  = "models: remember token is required"
-	ErrUserIDRequired privateError  = "models: user ID is required"
+	ErrRememberRequired privateError = "models: remember token is required"
+	ErrUserIDRequired   privateError = "models: user ID is required"
+	// ErrRoleInvalid is returned when a user is given a role that doesn't exist
+	ErrRoleInvalid privateError = "models: role is not valid"
+	// ErrActionRequired is returned when an audit entry doesn't say what happened
+	ErrActionRequired privateError = "models: audit action is required"
 )
 
 type modelError string
@@ -50,4 +95,4 @@ type privateError string
 
 func (e privateError) Error() string {
 	return string(e)
-}
\ No newline at end of file
+}
@@ -1,12 +1,19 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"context"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/jobs"
+)
 
 // Gallery is our image container resource that visitors view
 type Gallery struct {
 	gorm.Model
-	UserID uint   `gorm:"not_null;index"`
-	Title  string `gorm:"not_null"`
+	UserID uint    `gorm:"not_null;index"`
+	Title  string  `gorm:"not_null"`
+	Images []Image `gorm:"-"`
 }
 
 type GalleryService interface {
@@ -14,27 +21,101 @@ type GalleryService interface {
 }
 
 type GalleryDB interface {
+	ByID(id uint) (*Gallery, error)
+	// ByUserID returns every gallery the user owns, newest
+	// first.
+	ByUserID(userID uint) ([]Gallery, error)
+	// Search returns galleries whose title contains query,
+	// newest first. An empty query matches every gallery.
+	Search(query string, limit, offset int) ([]Gallery, error)
+
 	Create(gallery *Gallery) error
+	Update(gallery *Gallery) error
+	Delete(id uint) error
 }
 
-func NewGalleryService(db *gorm.DB) GalleryService {
-	return &galleryService{
+// NewGalleryService returns a GalleryService that deletes the
+// images of deleted galleries with jobs enqueued in queue
+func NewGalleryService(db *gorm.DB, images ImageService, queue *jobs.Queue) GalleryService {
+	gs := &galleryService{
 		GalleryDB: &galleryValidator{&galleryGorm{db}},
+		images:    images,
+		jobs:      queue,
 	}
+	queue.Handle(gs.handleDeleteImages)
+	return gs
 }
 
 type galleryService struct {
 	GalleryDB
+	images ImageService
+	jobs   *jobs.Queue
+}
+
+// DeleteGalleryImagesJob deletes the images of a deleted
+// gallery in the background
+type DeleteGalleryImagesJob struct {
+	GalleryID uint
+}
+
+func (DeleteGalleryImagesJob) JobType() string {
+	return "galleries.delete_images"
+}
+
+// Delete deletes the gallery, and then enqueues a job to delete
+// its images, which can't be brought back
+func (gs *galleryService) Delete(id uint) error {
+	if err := gs.GalleryDB.Delete(id); err != nil {
+		return err
+	}
+	return gs.jobs.Enqueue(DeleteGalleryImagesJob{GalleryID: id})
+}
+
+func (gs *galleryService) handleDeleteImages(ctx context.Context, job DeleteGalleryImagesJob) error {
+	return gs.images.DeleteByGalleryID(job.GalleryID)
 }
 
 func (gv *galleryValidator) Create(gallery *Gallery) error {
-	err := runGalleryValidatorFunc(gallery, gv.titleRequired,  gv.userIDRequired)
+	err := runGalleryValidatorFunc(gallery,
+		gv.normalizeTitle,
+		gv.titleRequired,
+		gv.userIDRequired)
 	if err != nil {
 		return err
 	}
 	return gv.GalleryDB.Create(gallery)
 }
 
+func (gv *galleryValidator) Update(gallery *Gallery) error {
+	err := runGalleryValidatorFunc(gallery,
+		gv.idGreaterThan(0),
+		gv.normalizeTitle,
+		gv.titleRequired,
+		gv.userIDRequired)
+	if err != nil {
+		return err
+	}
+	return gv.GalleryDB.Update(gallery)
+}
+
+func (gv *galleryValidator) Delete(id uint) error {
+	var gallery Gallery
+	gallery.ID = id
+	if err := runGalleryValidatorFunc(&gallery, gv.idGreaterThan(0)); err != nil {
+		return err
+	}
+	return gv.GalleryDB.Delete(id)
+}
+
+func (gv *galleryValidator) idGreaterThan(n uint) galleryValidatorFunc {
+	return galleryValidatorFunc(func(g *Gallery) error {
+		if g.ID <= n {
+			return ErrIDInvalid
+		}
+		return nil
+	})
+}
+
 func (gv *galleryValidator) userIDRequired(g *Gallery) error {
 	if g.UserID <= 0 {
 		return ErrUserIDRequired
@@ -42,6 +123,11 @@ func (gv *galleryValidator) userIDRequired(g *Gallery) error {
 	return nil
 }
 
+func (gv *galleryValidator) normalizeTitle(g *Gallery) error {
+	g.Title = strings.TrimSpace(g.Title)
+	return nil
+}
+
 func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	if g.Title == "" {
 		return ErrTitleRequired
@@ -49,6 +135,8 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+var _ GalleryDB = &galleryValidator{}
+
 type galleryValidator struct {
 	GalleryDB
 }
@@ -59,10 +147,52 @@ type galleryGorm struct {
 	db *gorm.DB
 }
 
+func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.Where("id = ?", id)
+	err := first(db, &gallery)
+	if err != nil {
+		return nil, err
+	}
+	return &gallery, nil
+}
+
+func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (gg *galleryGorm) Search(query string, limit, offset int) ([]Gallery, error) {
+	var galleries []Gallery
+	db := gg.db.Order("created_at desc").Limit(limit).Offset(offset)
+	if query != "" {
+		db = db.Where(`LOWER(title) LIKE ? ESCAPE '\'`, containsPattern(strings.ToLower(query)))
+	}
+	if err := db.Find(&galleries).Error; err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
 func (gg *galleryGorm) Create(gallery *Gallery) error {
 	return gg.db.Create(gallery).Error
 }
 
+func (gg *galleryGorm) Update(gallery *Gallery) error {
+	return gg.db.Save(gallery).Error
+}
+
+func (gg *galleryGorm) Delete(id uint) error {
+	gallery := Gallery{Model: gorm.Model{ID: id}}
+	return gg.db.Delete(&gallery).Error
+}
+
 type galleryValidatorFunc func(*Gallery) error
 
 func runGalleryValidatorFunc(gallery *Gallery, fns ...galleryValidatorFunc) error {
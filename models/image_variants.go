@@ -0,0 +1,211 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"lenslocked.com/imaging"
+	"lenslocked.com/jobs"
+	"lenslocked.com/storage"
+)
+
+// maxImagePixels is the most pixels an image can have for
+// variants to be made of it. Decoding takes 4 bytes per pixel,
+// however well the file is compressed.
+const maxImagePixels = 50_000_000
+
+// variantsDir holds the variants of a gallery's images. Image
+// filenames can't start with a dot, so it can't clash with one.
+const variantsDir = ".variants"
+
+// VariantPreset describes a resized copy made of every image.
+// The copy fits within MaxWidth x MaxHeight; images are never
+// enlarged.
+type VariantPreset struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+	// Quality is the JPEG quality, from 1 to 100
+	Quality int
+}
+
+// ImageVariant is a resized copy of an image
+type ImageVariant struct {
+	Preset string
+	Width  int
+	Height int
+	key    string
+}
+
+// Path is the URL the variant is served from
+func (v *ImageVariant) Path() string {
+	return imageURL(v.key)
+}
+
+// CreateVariantsJob makes the variants of a newly uploaded
+// image in the background
+type CreateVariantsJob struct {
+	GalleryID uint
+	Filename  string
+}
+
+func (CreateVariantsJob) JobType() string {
+	return "images.create_variants"
+}
+
+// handleCreateVariants is the handler for CreateVariantsJob.
+// Images that turn out not to be readable are deleted, as they
+// would have been rejected had they been checked while being
+// uploaded.
+func (is *imageService) handleCreateVariants(ctx context.Context, job CreateVariantsJob) error {
+	image := Image{GalleryID: job.GalleryID, Filename: job.Filename}
+	err := is.CreateVariants(&image)
+	switch err {
+	case nil:
+		return nil
+	case storage.ErrNotFound:
+		// deleted before the job ran
+		return nil
+	case ErrImageCorrupt, ErrImageTooLarge:
+		if derr := is.Delete(&image); derr != nil && derr != ErrNotFound {
+			return derr
+		}
+		return jobs.Permanent(err)
+	default:
+		return err
+	}
+}
+
+// validPresets makes sure variants can be made, and told apart,
+// for each of the presets
+func validPresets(presets []VariantPreset) error {
+	seen := make(map[string]bool)
+	for _, p := range presets {
+		if p.Name == "" || strings.HasPrefix(p.Name, ".") || strings.ContainsAny(p.Name, "/\\") {
+			return fmt.Errorf("models: variant preset name %q is not valid", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("models: variant preset %q is defined twice", p.Name)
+		}
+		seen[p.Name] = true
+		if p.MaxWidth <= 0 || p.MaxHeight <= 0 {
+			return fmt.Errorf("models: variant preset %q needs a positive size", p.Name)
+		}
+		if p.Quality < 1 || p.Quality > 100 {
+			return fmt.Errorf("models: variant preset %q needs a quality from 1 to 100", p.Name)
+		}
+	}
+	return nil
+}
+
+// CreateVariants decodes the stored image once, and then
+// stores a resized copy of it for each preset. Variants are
+// named after their preset and size, so the ones made for
+// presets that have since changed are left over, and removed.
+func (is *imageService) CreateVariants(image *Image) error {
+	if err := validImage(image); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	rc, _, err := is.blob.Get(ctx, image.key())
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	img, err := imaging.Decode(bytes.NewReader(data), maxImagePixels)
+	if err == imaging.ErrTooLarge {
+		return ErrImageTooLarge
+	}
+	if err != nil {
+		return ErrImageCorrupt
+	}
+
+	keep := make(map[string]bool)
+	for _, p := range is.presets {
+		resized := imaging.Fit(img, p.MaxWidth, p.MaxHeight)
+		var buf bytes.Buffer
+		contentType, err := imaging.Encode(&buf, resized, p.Quality)
+		if err != nil {
+			return err
+		}
+		ext := ".jpg"
+		if contentType == "image/png" {
+			ext = ".png"
+		}
+		key := variantKey(image, p.Name, resized.Rect.Dx(), resized.Rect.Dy(), ext)
+		if err := is.blob.Put(ctx, key, &buf, contentType); err != nil {
+			return err
+		}
+		keep[key] = true
+	}
+	return is.deleteVariants(ctx, image, keep)
+}
+
+// deleteVariants removes the image's variants, apart from the
+// ones in keep
+func (is *imageService) deleteVariants(ctx context.Context, image *Image, keep map[string]bool) error {
+	infos, err := is.blob.List(ctx, variantPrefix(image))
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if keep[info.Key] {
+			continue
+		}
+		if err := is.blob.Delete(ctx, info.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantPrefix starts the keys of the image's variants
+func variantPrefix(image *Image) string {
+	return galleryPrefix(image.GalleryID) + variantsDir + "/" + image.Filename + "/"
+}
+
+// variantKey is where a variant is kept in storage, such as
+// galleries/1/.variants/photo.jpg/thumbnail-400x300.jpg
+func variantKey(image *Image, preset string, width, height int, ext string) string {
+	return fmt.Sprintf("%s%s-%dx%d%s", variantPrefix(image), preset, width, height, ext)
+}
+
+// imageVariantKey is a variant along with the image it was
+// made from, as read back from its key
+type imageVariantKey struct {
+	ImageVariant
+	GalleryID uint
+	Filename  string
+}
+
+// parseVariantKey is the reverse of variantKey
+func parseVariantKey(key string) (imageVariantKey, bool) {
+	var v imageVariantKey
+	parts := strings.Split(key, "/")
+	if len(parts) != 5 || parts[0] != "galleries" || parts[2] != variantsDir {
+		return v, false
+	}
+	if _, err := fmt.Sscan(parts[1], &v.GalleryID); err != nil {
+		return v, false
+	}
+	v.Filename = parts[3]
+	name := strings.TrimSuffix(parts[4], path.Ext(parts[4]))
+	i := strings.LastIndex(name, "-")
+	if i <= 0 {
+		return v, false
+	}
+	v.Preset = name[:i]
+	if n, _ := fmt.Sscanf(name[i+1:], "%dx%d", &v.Width, &v.Height); n != 2 {
+		return v, false
+	}
+	v.key = key
+	return v, true
+}
@@ -0,0 +1,315 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"lenslocked.com/jobs"
+	"lenslocked.com/rand"
+	"lenslocked.com/storage"
+)
+
+// imageTypes maps the content types that can be uploaded, as
+// reported by http.DetectContentType, to the extension images
+// of that type are stored with
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// imageSuffixBytes is the number of random bytes added to the
+// name of every uploaded image
+const imageSuffixBytes = 6
+
+// Image is a photo in a gallery. Images aren't stored in the
+// database; they are kept in storage under a prefix for each
+// gallery.
+type Image struct {
+	GalleryID uint
+	Filename  string
+	// Variants are the resized copies of the image, smallest
+	// first. They are only set by ByGalleryID.
+	Variants []ImageVariant
+}
+
+// Path is the URL the image is served from
+func (i *Image) Path() string {
+	return imageURL(i.key())
+}
+
+// Thumbnail is the URL of the smallest variant of the image,
+// or of the original if it has no variants yet
+func (i *Image) Thumbnail() string {
+	if len(i.Variants) == 0 {
+		return i.Path()
+	}
+	return i.Variants[0].Path()
+}
+
+// ImageService stores the images uploaded to galleries
+type ImageService interface {
+	// Create stores the image read from r in the gallery. It
+	// fails with ErrImageType unless r holds a JPEG, PNG or GIF,
+	// and with ErrImageTooLarge if it is over the size limit.
+	Create(galleryID uint, r io.Reader, filename string) (*Image, error)
+	// ByGalleryID returns the gallery's images ordered by
+	// filename
+	ByGalleryID(galleryID uint) ([]Image, error)
+	Delete(image *Image) error
+	// DeleteByGalleryID removes every image in the gallery
+	DeleteByGalleryID(galleryID uint) error
+	// All returns the images of every gallery, without their
+	// variants
+	All() ([]Image, error)
+	// CreateVariants makes a resized copy of the image for each
+	// preset, and removes variants left from older presets.
+	// Create enqueues a CreateVariantsJob to do this.
+	CreateVariants(image *Image) error
+}
+
+// NewImageService returns an ImageService that keeps images
+// in blob, and rejects images larger than maxSize bytes. A
+// variant of every image is made for each of the presets, by a
+// job enqueued in queue.
+func NewImageService(blob storage.Blob, maxSize int64, presets []VariantPreset, queue *jobs.Queue) ImageService {
+	is := &imageService{
+		blob:    blob,
+		maxSize: maxSize,
+		presets: presets,
+		jobs:    queue,
+	}
+	queue.Handle(is.handleCreateVariants)
+	return is
+}
+
+var _ ImageService = &imageService{}
+
+type imageService struct {
+	blob    storage.Blob
+	maxSize int64
+	presets []VariantPreset
+	jobs    *jobs.Queue
+}
+
+// Create stores the image under its filename, with a random
+// suffix so uploads never replace one another, and with the
+// extension replaced by the one for its actual type. Otherwise
+// a GIF uploaded as evil.html would be served as a web page.
+func (is *imageService) Create(galleryID uint, r io.Reader, filename string) (*Image, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head)
+	ext, ok := imageTypes[contentType]
+	if !ok {
+		return nil, ErrImageType
+	}
+	name, err := imageFilename(filename, ext)
+	if err != nil {
+		return nil, err
+	}
+	image := Image{
+		GalleryID: galleryID,
+		Filename:  name,
+	}
+	if err := validImage(&image); err != nil {
+		return nil, err
+	}
+	// the upload fails as soon as it goes over the limit
+	body := &sizeLimitReader{r: br, n: is.maxSize}
+	err = is.blob.Put(context.Background(), image.key(), body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	job := CreateVariantsJob{GalleryID: image.GalleryID, Filename: image.Filename}
+	if err := is.jobs.Enqueue(job); err != nil {
+		// the name is new, so this only removes what we just stored
+		if derr := is.blob.Delete(context.Background(), image.key()); derr != nil {
+			log.Println(derr)
+		}
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
+	prefix := galleryPrefix(galleryID)
+	infos, err := is.blob.List(context.Background(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	var images []Image
+	variants := make(map[string][]ImageVariant)
+	for _, info := range infos {
+		filename := strings.TrimPrefix(info.Key, prefix)
+		if !strings.Contains(filename, "/") {
+			images = append(images, Image{
+				GalleryID: galleryID,
+				Filename:  filename,
+			})
+			continue
+		}
+		if v, ok := parseVariantKey(info.Key); ok && v.GalleryID == galleryID {
+			variants[v.Filename] = append(variants[v.Filename], v.ImageVariant)
+		}
+	}
+	for i := range images {
+		vs := variants[images[i].Filename]
+		sort.Slice(vs, func(a, b int) bool {
+			return vs[a].Width < vs[b].Width
+		})
+		images[i].Variants = vs
+	}
+	return images, nil
+}
+
+func (is *imageService) All() ([]Image, error) {
+	infos, err := is.blob.List(context.Background(), "galleries/")
+	if err != nil {
+		return nil, err
+	}
+	var images []Image
+	for _, info := range infos {
+		var image Image
+		parts := strings.Split(info.Key, "/")
+		if len(parts) != 3 {
+			continue
+		}
+		if _, err := fmt.Sscan(parts[1], &image.GalleryID); err != nil {
+			continue
+		}
+		image.Filename = parts[2]
+		if validImage(&image) != nil || image.key() != info.Key {
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func (is *imageService) Delete(image *Image) error {
+	if err := validImage(image); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if _, err := is.blob.Stat(ctx, image.key()); err != nil {
+		if err == storage.ErrNotFound {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := is.deleteVariants(ctx, image, nil); err != nil {
+		return err
+	}
+	return is.blob.Delete(ctx, image.key())
+}
+
+func (is *imageService) DeleteByGalleryID(galleryID uint) error {
+	if galleryID <= 0 {
+		return ErrIDInvalid
+	}
+	ctx := context.Background()
+	infos, err := is.blob.List(ctx, galleryPrefix(galleryID))
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := is.blob.Delete(ctx, info.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// galleryPrefix starts the keys of everything stored for a
+// gallery
+func galleryPrefix(galleryID uint) string {
+	return fmt.Sprintf("galleries/%d/", galleryID)
+}
+
+// key is where the image is kept in storage
+func (i *Image) key() string {
+	return galleryPrefix(i.GalleryID) + i.Filename
+}
+
+// imageURL is the URL of the object stored with key
+func imageURL(key string) string {
+	u := url.URL{Path: "/images/" + key}
+	return u.String()
+}
+
+// sizeLimitReader fails with ErrImageTooLarge once more than
+// n bytes have been read from r
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (lr *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	if lr.n < 0 {
+		return n, ErrImageTooLarge
+	}
+	return n, err
+}
+
+// imageFilename is the name an uploaded file is stored under:
+// its cleaned name and a random suffix, with ext in place of
+// its own extension. Files with nothing left of their name
+// once it is cleaned get just the random part.
+func imageFilename(filename, ext string) (string, error) {
+	b, err := rand.Bytes(imageSuffixBytes)
+	if err != nil {
+		return "", err
+	}
+	suffix := hex.EncodeToString(b)
+	name := cleanImageFilename(filename)
+	stem := strings.TrimLeft(strings.TrimSuffix(name, path.Ext(name)), ".")
+	if stem == "" {
+		return suffix + ext, nil
+	}
+	return stem + "-" + suffix + ext, nil
+}
+
+// cleanImageFilename drops any directories from an uploaded
+// file's name, and characters that would need escaping in URLs
+func cleanImageFilename(filename string) string {
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '-', r == '_':
+			return r
+		case r == ' ':
+			return '-'
+		}
+		return -1
+	}, filename)
+}
+
+// validImage makes sure an image can't point outside of its
+// gallery
+func validImage(image *Image) error {
+	if image.GalleryID <= 0 {
+		return ErrIDInvalid
+	}
+	name := image.Filename
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\") {
+		return ErrFilenameInvalid
+	}
+	return nil
+}
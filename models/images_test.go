@@ -0,0 +1,125 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"regexp"
+	"testing"
+
+	"lenslocked.com/jobs"
+	"lenslocked.com/storage"
+)
+
+func newTestImageService(t *testing.T) (*imageService, storage.Blob, *jobs.MemoryStore) {
+	t.Helper()
+	blob := storage.NewLocal(t.TempDir())
+	store := jobs.NewMemoryStore()
+	queue := jobs.NewQueue(store, jobs.Config{})
+	presets := []VariantPreset{{Name: "small", MaxWidth: 4, MaxHeight: 4, Quality: 80}}
+	is := NewImageService(blob, 1<<20, presets, queue).(*imageService)
+	return is, blob, store
+}
+
+func testImage(t *testing.T, format string) []byte {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, 8, 8), []color.Color{color.Black, color.White})
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageCreateUsesDetectedType(t *testing.T) {
+	is, blob, store := newTestImageService(t)
+	tests := []struct {
+		filename string
+		data     []byte
+		want     string
+		wantType string
+	}{
+		{"evil.html", testImage(t, "gif"), `^evil-[0-9a-f]{12}\.gif$`, "image/gif"},
+		{"photo.JPG", testImage(t, "png"), `^photo-[0-9a-f]{12}\.png$`, "image/png"},
+		{"no extension", testImage(t, "png"), `^no-extension-[0-9a-f]{12}\.png$`, "image/png"},
+		{`C:\Users\jon\cat.gif.svg`, testImage(t, "gif"), `^cat\.gif-[0-9a-f]{12}\.gif$`, "image/gif"},
+		{"日本.jpg", testImage(t, "png"), `^[0-9a-f]{12}\.png$`, "image/png"},
+		{"../.gif", testImage(t, "gif"), `^[0-9a-f]{12}\.gif$`, "image/gif"},
+		{".htaccess", testImage(t, "gif"), `^[0-9a-f]{12}\.gif$`, "image/gif"},
+	}
+	for _, tc := range tests {
+		image, err := is.Create(1, bytes.NewReader(tc.data), tc.filename)
+		if err != nil {
+			t.Errorf("Create(%q) err = %v", tc.filename, err)
+			continue
+		}
+		if !regexp.MustCompile(tc.want).MatchString(image.Filename) {
+			t.Errorf("Create(%q) filename = %q, want it to match %s", tc.filename, image.Filename, tc.want)
+		}
+		info, err := blob.Stat(context.Background(), image.key())
+		if err != nil {
+			t.Errorf("Stat(%q) err = %v", image.key(), err)
+			continue
+		}
+		if info.ContentType != tc.wantType {
+			t.Errorf("%q stored as %q, want %q", tc.filename, info.ContentType, tc.wantType)
+		}
+	}
+	if got := len(store.Jobs()); got != len(tests) {
+		t.Errorf("%d jobs enqueued, want one CreateVariantsJob per image", got)
+	}
+}
+
+func TestImageCreateRejects(t *testing.T) {
+	is, _, _ := newTestImageService(t)
+	tests := []struct {
+		filename string
+		data     []byte
+		want     error
+	}{
+		{"page.html", []byte("<html><script>alert(1)</script></html>"), ErrImageType},
+		{"notes.gif", []byte("just some text"), ErrImageType},
+	}
+	for _, tc := range tests {
+		if _, err := is.Create(1, bytes.NewReader(tc.data), tc.filename); err != tc.want {
+			t.Errorf("Create(%q) err = %v, want %v", tc.filename, err, tc.want)
+		}
+	}
+	big := append(testImage(t, "png"), make([]byte, is.maxSize)...)
+	if _, err := is.Create(1, bytes.NewReader(big), "big.png"); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Create() with a file over the limit err = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestImageCreateKeepsSameNamedImages(t *testing.T) {
+	is, _, _ := newTestImageService(t)
+	first, err := is.Create(1, bytes.NewReader(testImage(t, "png")), "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := is.Create(1, bytes.NewReader(testImage(t, "gif")), "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Filename == second.Filename {
+		t.Fatalf("both uploads were stored as %q", first.Filename)
+	}
+	images, err := is.ByGalleryID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Errorf("gallery has %d images, want 2", len(images))
+	}
+}
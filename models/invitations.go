@@ -0,0 +1,300 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+// invitationCodeBytes is how much randomness each invitation
+// code has
+const invitationCodeBytes = 16
+
+// Invitation lets people sign up while registration is invite
+// only. It can be used MaxUses times before ExpiresAt, and only
+// the HMAC of its code is stored.
+type Invitation struct {
+	gorm.Model
+	// CreatedByID is the user who issued the invitation
+	CreatedByID uint `gorm:"not null;index"`
+	// Email, when set, is the only address that can sign up
+	// with the invitation
+	Email     string
+	Code      string `gorm:"-"`
+	CodeHash  string `gorm:"not null;unique_index"`
+	MaxUses   int    `gorm:"not null"`
+	Uses      int    `gorm:"not null;default:0"`
+	ExpiresAt time.Time
+}
+
+// Usable reports whether someone can still sign up with the
+// invitation
+func (inv *Invitation) Usable() bool {
+	return inv.Uses < inv.MaxUses && time.Now().Before(inv.ExpiresAt)
+}
+
+// InvitationDB is used to interact with the invitations database
+type InvitationDB interface {
+	ByID(id uint) (*Invitation, error)
+	// ByCode looks up an invitation by its raw code. Used up
+	// and expired invitations are still returned.
+	ByCode(code string) (*Invitation, error)
+	// ByCreator returns the invitations the user issued,
+	// newest first.
+	ByCreator(userID uint) ([]Invitation, error)
+
+	Create(inv *Invitation) error
+	Delete(id uint) error
+	// Redeem records one use of the invitation, failing with
+	// ErrInvitationInvalid if it has been used up or expired
+	// in the meantime.
+	Redeem(inv *Invitation) error
+	// Release gives back a use recorded by Redeem, for when the
+	// signup it was redeemed for fails.
+	Release(inv *Invitation) error
+}
+
+// InvitationService is a set of methods used to manipulate
+// and work with invitations
+type InvitationService interface {
+	InvitationDB
+}
+
+func NewInvitationService(db *gorm.DB, hmac *hash.Keyring) InvitationService {
+	return &invitationService{
+		InvitationDB: &invitationValidator{
+			InvitationDB: &invitationGorm{db},
+			hmac:         hmac,
+			emailRegex:   regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
+		},
+	}
+}
+
+var _ InvitationService = &invitationService{}
+
+type invitationService struct {
+	InvitationDB
+}
+
+type invitationValidatorFunc func(*Invitation) error
+
+func runInvitationValidatorFunc(inv *Invitation, fns ...invitationValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(inv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ InvitationDB = &invitationValidator{}
+
+type invitationValidator struct {
+	InvitationDB
+	hmac       *hash.Keyring
+	emailRegex *regexp.Regexp
+}
+
+// ByCode will hash the code with each of our HMAC keys,
+// newest first, and call ByCode on the InvitationDB field
+// until an invitation is found
+func (iv *invitationValidator) ByCode(code string) (*Invitation, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, ErrNotFound
+	}
+	for _, codeHash := range iv.hmac.Candidates(code) {
+		inv, err := iv.InvitationDB.ByCode(codeHash)
+		if err != ErrNotFound {
+			return inv, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (iv *invitationValidator) Create(inv *Invitation) error {
+	err := runInvitationValidatorFunc(inv,
+		iv.creatorRequired,
+		iv.normalizeEmail,
+		iv.singleUseIfEmail,
+		iv.maxUsesGreaterThan(0),
+		iv.expiryInFuture,
+		iv.setCodeIfUnset,
+		iv.hmacCode)
+	if err != nil {
+		return err
+	}
+	return iv.InvitationDB.Create(inv)
+}
+
+func (iv *invitationValidator) Delete(id uint) error {
+	var inv Invitation
+	inv.ID = id
+	if err := runInvitationValidatorFunc(&inv, iv.idGreaterThan(0)); err != nil {
+		return err
+	}
+	return iv.InvitationDB.Delete(id)
+}
+
+func (iv *invitationValidator) Redeem(inv *Invitation) error {
+	if !inv.Usable() {
+		return ErrInvitationInvalid
+	}
+	if err := runInvitationValidatorFunc(inv, iv.idGreaterThan(0)); err != nil {
+		return err
+	}
+	return iv.InvitationDB.Redeem(inv)
+}
+
+func (iv *invitationValidator) Release(inv *Invitation) error {
+	if err := runInvitationValidatorFunc(inv, iv.idGreaterThan(0)); err != nil {
+		return err
+	}
+	return iv.InvitationDB.Release(inv)
+}
+
+func (iv *invitationValidator) idGreaterThan(n uint) invitationValidatorFunc {
+	return invitationValidatorFunc(func(inv *Invitation) error {
+		if inv.ID <= n {
+			return ErrIDInvalid
+		}
+		return nil
+	})
+}
+
+func (iv *invitationValidator) creatorRequired(inv *Invitation) error {
+	if inv.CreatedByID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (iv *invitationValidator) normalizeEmail(inv *Invitation) error {
+	inv.Email = strings.ToLower(strings.TrimSpace(inv.Email))
+	if inv.Email != "" && !iv.emailRegex.MatchString(inv.Email) {
+		return ErrEmailInvalid
+	}
+	return nil
+}
+
+// singleUseIfEmail makes invitations for one address single
+// use, as only one account can have that address anyway
+func (iv *invitationValidator) singleUseIfEmail(inv *Invitation) error {
+	if inv.Email != "" {
+		inv.MaxUses = 1
+	}
+	return nil
+}
+
+func (iv *invitationValidator) maxUsesGreaterThan(n int) invitationValidatorFunc {
+	return invitationValidatorFunc(func(inv *Invitation) error {
+		if inv.MaxUses <= n {
+			return ErrMaxUsesInvalid
+		}
+		return nil
+	})
+}
+
+func (iv *invitationValidator) expiryInFuture(inv *Invitation) error {
+	if !inv.ExpiresAt.After(time.Now()) {
+		return ErrExpiryInvalid
+	}
+	return nil
+}
+
+func (iv *invitationValidator) setCodeIfUnset(inv *Invitation) error {
+	if inv.Code != "" {
+		return nil
+	}
+	code, err := rand.String(invitationCodeBytes)
+	if err != nil {
+		return err
+	}
+	inv.Code = code
+	return nil
+}
+
+func (iv *invitationValidator) hmacCode(inv *Invitation) error {
+	if inv.Code == "" {
+		return nil
+	}
+	inv.CodeHash = iv.hmac.Hash(inv.Code)
+	return nil
+}
+
+var _ InvitationDB = &invitationGorm{}
+
+type invitationGorm struct {
+	db *gorm.DB
+}
+
+func (ig *invitationGorm) ByID(id uint) (*Invitation, error) {
+	var inv Invitation
+	err := first(ig.db.Where("id = ?", id), &inv)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (ig *invitationGorm) ByCode(codeHash string) (*Invitation, error) {
+	var inv Invitation
+	err := first(ig.db.Where("code_hash = ?", codeHash), &inv)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (ig *invitationGorm) ByCreator(userID uint) ([]Invitation, error) {
+	var invs []Invitation
+	err := ig.db.Where("created_by_id = ?", userID).
+		Order("created_at desc").
+		Find(&invs).Error
+	if err != nil {
+		return nil, err
+	}
+	return invs, nil
+}
+
+func (ig *invitationGorm) Create(inv *Invitation) error {
+	return ig.db.Create(inv).Error
+}
+
+func (ig *invitationGorm) Delete(id uint) error {
+	inv := Invitation{Model: gorm.Model{ID: id}}
+	return ig.db.Unscoped().Delete(&inv).Error
+}
+
+// Redeem increments the use count in a single statement, so
+// two people signing up at once can't both take the last use
+func (ig *invitationGorm) Redeem(inv *Invitation) error {
+	db := ig.db.Model(&Invitation{}).
+		Where("id = ? AND uses < max_uses AND expires_at > ?", inv.ID, time.Now()).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrInvitationInvalid
+	}
+	inv.Uses++
+	return nil
+}
+
+func (ig *invitationGorm) Release(inv *Invitation) error {
+	db := ig.db.Model(&Invitation{}).
+		Where("id = ? AND uses > 0", inv.ID).
+		UpdateColumn("uses", gorm.Expr("uses - 1"))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected > 0 && inv.Uses > 0 {
+		inv.Uses--
+	}
+	return nil
+}
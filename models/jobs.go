@@ -0,0 +1,95 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/jobs"
+)
+
+// PurgeDeletedUsersJob runs PurgeDeletedUsers in the
+// background
+type PurgeDeletedUsersJob struct{}
+
+func (PurgeDeletedUsersJob) JobType() string {
+	return "users.purge_deleted"
+}
+
+// NewJobStore returns a jobs.Store that keeps jobs in the jobs
+// table, so they survive restarts and can be shared by several
+// processes
+func NewJobStore(db *gorm.DB) jobs.Store {
+	return &jobGorm{db}
+}
+
+var _ jobs.Store = &jobGorm{}
+
+type jobGorm struct {
+	db *gorm.DB
+}
+
+// Create relies on the unique index on key to keep unique jobs
+// from being stored twice. ON CONFLICT leaves the job that is
+// already there alone, and then no ID is returned.
+func (jg *jobGorm) Create(job *jobs.Job) error {
+	if job.Key == nil {
+		return jg.db.Create(job).Error
+	}
+	err := jg.db.Set("gorm:insert_option", "ON CONFLICT DO NOTHING").Create(job).Error
+	if err == sql.ErrNoRows {
+		return jobs.ErrDuplicate
+	}
+	return err
+}
+
+// Claim locks the row it picks with SKIP LOCKED, so workers in
+// other processes claiming at the same time each get a
+// different job instead of waiting on each other
+func (jg *jobGorm) Claim(types []string, now time.Time, lease time.Duration) (*jobs.Job, error) {
+	var job jobs.Job
+	err := jg.db.Raw(`UPDATE jobs
+		SET status = ?, attempts = attempts + 1, locked_until = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE type IN (?) AND (
+				status = ? AND run_at <= ? OR
+				status = ? AND locked_until <= ?)
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`,
+		jobs.StatusRunning, now.Add(lease), now,
+		types,
+		jobs.StatusPending, now,
+		jobs.StatusRunning, now).
+		Scan(&job).Error
+	switch err {
+	case nil:
+		return &job, nil
+	case gorm.ErrRecordNotFound:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+func (jg *jobGorm) Update(job *jobs.Job) error {
+	return jg.db.Save(job).Error
+}
+
+func (jg *jobGorm) Delete(job *jobs.Job) error {
+	return jg.db.Delete(job).Error
+}
+
+// handlePurgeDeletedUsers is the handler for
+// PurgeDeletedUsersJob
+func (s *Services) handlePurgeDeletedUsers(ctx context.Context, job PurgeDeletedUsersJob) error {
+	n, err := s.PurgeDeletedUsers()
+	if n > 0 {
+		log.Printf("Purged %d deleted accounts\n", n)
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LoginAttempt tracks consecutive failed logins for a single
+// key, which is either an account ("email:...") or a client
+// IP address ("ip:...").
+type LoginAttempt struct {
+	gorm.Model
+	Key           string `gorm:"not null;unique_index"`
+	Failures      int    `gorm:"not null"`
+	LastFailureAt time.Time
+}
+
+// AttemptStore is used to persist failed login attempts so
+// Authenticate can slow down and lock out password guessing.
+type AttemptStore interface {
+	// ByKey returns the attempts recorded for key, or a zero
+	// valued LoginAttempt if there are none.
+	ByKey(key string) (*LoginAttempt, error)
+	// RecordFailure increments the failure count for key.
+	RecordFailure(key string, at time.Time) error
+	// Reset clears all failures recorded for key.
+	Reset(key string) error
+}
+
+// NewMemoryAttemptStore returns an AttemptStore that keeps
+// everything in memory. It is only suitable for tests and
+// single process deployments.
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{
+		attempts: make(map[string]LoginAttempt),
+	}
+}
+
+type memoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempt
+}
+
+func (mas *memoryAttemptStore) ByKey(key string) (*LoginAttempt, error) {
+	mas.mu.Lock()
+	defer mas.mu.Unlock()
+	la, ok := mas.attempts[key]
+	if !ok {
+		la = LoginAttempt{Key: key}
+	}
+	return &la, nil
+}
+
+func (mas *memoryAttemptStore) RecordFailure(key string, at time.Time) error {
+	mas.mu.Lock()
+	defer mas.mu.Unlock()
+	la := mas.attempts[key]
+	la.Key = key
+	la.Failures++
+	la.LastFailureAt = at
+	mas.attempts[key] = la
+	return nil
+}
+
+func (mas *memoryAttemptStore) Reset(key string) error {
+	mas.mu.Lock()
+	defer mas.mu.Unlock()
+	delete(mas.attempts, key)
+	return nil
+}
+
+var _ AttemptStore = &attemptGorm{}
+
+type attemptGorm struct {
+	db *gorm.DB
+}
+
+func (ag *attemptGorm) ByKey(key string) (*LoginAttempt, error) {
+	var la LoginAttempt
+	err := first(ag.db.Where("key = ?", key), &la)
+	switch err {
+	case nil:
+		return &la, nil
+	case ErrNotFound:
+		return &LoginAttempt{Key: key}, nil
+	default:
+		return nil, err
+	}
+}
+
+// RecordFailure increments the counter with a single UPDATE so
+// concurrent failures for the same key are never lost, and
+// only inserts a row when the key hasn't failed before.
+func (ag *attemptGorm) RecordFailure(key string, at time.Time) error {
+	res := ag.db.Model(&LoginAttempt{}).Where("key = ?", key).
+		Updates(map[string]interface{}{
+			"failures":        gorm.Expr("failures + 1"),
+			"last_failure_at": at,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	la := LoginAttempt{
+		Key:           key,
+		Failures:      1,
+		LastFailureAt: at,
+	}
+	return ag.db.Create(&la).Error
+}
+
+func (ag *attemptGorm) Reset(key string) error {
+	return ag.db.Unscoped().Where("key = ?", key).Delete(&LoginAttempt{}).Error
+}
+
+// throttlePolicy decides how long a key has to wait after a
+// number of consecutive failures.
+type throttlePolicy struct {
+	// freeFailures is how many failures are allowed before any
+	// delay is enforced
+	freeFailures int
+	// baseDelay is the first delay enforced, and doubles with
+	// every failure after that up to maxDelay
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	// lockoutAfter failures the key is locked out entirely
+	// for lockoutDuration
+	lockoutAfter    int
+	lockoutDuration time.Duration
+}
+
+// wait returns how long after the last failure the next
+// attempt has to wait.
+func (tp throttlePolicy) wait(failures int) time.Duration {
+	if failures >= tp.lockoutAfter {
+		return tp.lockoutDuration
+	}
+	if failures <= tp.freeFailures {
+		return 0
+	}
+	delay := tp.baseDelay
+	for i := tp.freeFailures + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= tp.maxDelay {
+			return tp.maxDelay
+		}
+	}
+	return delay
+}
+
+var (
+	accountThrottle = throttlePolicy{
+		freeFailures:    3,
+		baseDelay:       2 * time.Second,
+		maxDelay:        2 * time.Minute,
+		lockoutAfter:    10,
+		lockoutDuration: 15 * time.Minute,
+	}
+	// many users can share an IP address, so IPs get more room
+	ipThrottle = throttlePolicy{
+		freeFailures:    20,
+		baseDelay:       time.Second,
+		maxDelay:        time.Minute,
+		lockoutAfter:    100,
+		lockoutDuration: time.Hour,
+	}
+)
+
+// loginThrottle applies a throttlePolicy to the attempts kept
+// in an AttemptStore.
+type loginThrottle struct {
+	store AttemptStore
+	now   func() time.Time
+}
+
+// allow returns ErrTooManyAttempts if key has failed too often
+// to be allowed another attempt right now. Failures older than
+// the lockout duration are forgotten.
+func (lt *loginThrottle) allow(key string, policy throttlePolicy) error {
+	la, err := lt.store.ByKey(key)
+	if err != nil {
+		return err
+	}
+	if la.Failures == 0 {
+		return nil
+	}
+	since := lt.now().Sub(la.LastFailureAt)
+	if since >= policy.lockoutDuration {
+		return lt.store.Reset(key)
+	}
+	if since < policy.wait(la.Failures) {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+func (lt *loginThrottle) fail(key string) error {
+	return lt.store.RecordFailure(key, lt.now())
+}
+
+func (lt *loginThrottle) reset(key string) error {
+	return lt.store.Reset(key)
+}
+
+func accountKey(email string) string {
+	return "email:" + email
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
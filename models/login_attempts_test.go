@@ -0,0 +1,172 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"lenslocked.com/hash"
+)
+
+func TestThrottlePolicyWait(t *testing.T) {
+	policy := throttlePolicy{
+		freeFailures:    3,
+		baseDelay:       2 * time.Second,
+		maxDelay:        10 * time.Second,
+		lockoutAfter:    10,
+		lockoutDuration: 15 * time.Minute,
+	}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{9, 10 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+	for _, tc := range tests {
+		if got := policy.wait(tc.failures); got != tc.want {
+			t.Errorf("wait(%d) = %v, want %v", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestLoginThrottleAllow(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lt := &loginThrottle{store: NewMemoryAttemptStore(), now: clock.now}
+	key := accountKey("jon@example.com")
+	fail := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := lt.fail(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	fail(accountThrottle.freeFailures)
+	if err := lt.allow(key, accountThrottle); err != nil {
+		t.Fatalf("allow() after %d failures = %v, want nil", accountThrottle.freeFailures, err)
+	}
+
+	fail(1)
+	if err := lt.allow(key, accountThrottle); err != ErrTooManyAttempts {
+		t.Fatalf("allow() right after a delayed failure = %v, want ErrTooManyAttempts", err)
+	}
+	clock.advance(accountThrottle.baseDelay)
+	if err := lt.allow(key, accountThrottle); err != nil {
+		t.Fatalf("allow() once the delay is over = %v, want nil", err)
+	}
+
+	fail(accountThrottle.lockoutAfter)
+	clock.advance(accountThrottle.lockoutDuration - time.Second)
+	if err := lt.allow(key, accountThrottle); err != ErrTooManyAttempts {
+		t.Fatalf("allow() during lockout = %v, want ErrTooManyAttempts", err)
+	}
+	clock.advance(time.Second)
+	if err := lt.allow(key, accountThrottle); err != nil {
+		t.Fatalf("allow() once the lockout expired = %v, want nil", err)
+	}
+	la, _ := lt.store.ByKey(key)
+	if la.Failures != 0 {
+		t.Errorf("failures after the lockout expired = %d, want them forgotten", la.Failures)
+	}
+}
+
+func TestAuthenticateFrom(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+	store := us.throttle.store
+	failures := func(key string) int {
+		la, err := store.ByKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return la.Failures
+	}
+
+	// the account key ignores case and spaces in the email
+	for i := 0; i < accountThrottle.freeFailures+1; i++ {
+		_, err := us.AuthenticateFrom("10.0.0.1", " JON@example.com", "wrong")
+		if err != ErrPasswordInCorrect {
+			t.Fatalf("attempt %d: err = %v, want ErrPasswordInCorrect", i+1, err)
+		}
+	}
+	if got, want := failures(accountKey("jon@example.com")), accountThrottle.freeFailures+1; got != want {
+		t.Errorf("account failures = %d, want %d", got, want)
+	}
+	if got, want := failures(ipKey("10.0.0.1")), accountThrottle.freeFailures+1; got != want {
+		t.Errorf("IP failures = %d, want %d", got, want)
+	}
+
+	// even the right password has to wait out the delay
+	if _, err := us.AuthenticateFrom("10.0.0.2", "jon@example.com", "correct horse"); err != ErrTooManyAttempts {
+		t.Fatalf("correct password during delay: err = %v, want ErrTooManyAttempts", err)
+	}
+	clock.advance(accountThrottle.baseDelay)
+	user, err := us.AuthenticateFrom("10.0.0.1", "jon@example.com", "correct horse")
+	if err != nil {
+		t.Fatalf("correct password after delay: err = %v", err)
+	}
+	if user.Email != "jon@example.com" {
+		t.Errorf("user.Email = %q, want %q", user.Email, "jon@example.com")
+	}
+	// logging in clears the account, but not the IP, which
+	// might be guessing at other accounts too
+	if got := failures(accountKey("jon@example.com")); got != 0 {
+		t.Errorf("account failures after logging in = %d, want 0", got)
+	}
+	if got := failures(ipKey("10.0.0.1")); got == 0 {
+		t.Errorf("IP failures after logging in = 0, want them kept")
+	}
+
+	// unknown accounts count against the IP too
+	if _, err := us.AuthenticateFrom("10.0.0.3", "nobody@example.com", "x"); err != ErrNotFound {
+		t.Fatalf("unknown email: err = %v, want ErrNotFound", err)
+	}
+	if got := failures(ipKey("10.0.0.3")); got != 1 {
+		t.Errorf("IP failures for an unknown email = %d, want 1", got)
+	}
+}
+
+func TestAuthenticateFromIPLockout(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+	for i := 0; i < ipThrottle.lockoutAfter; i++ {
+		if err := us.throttle.fail(ipKey("10.0.0.1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := us.AuthenticateFrom("10.0.0.1", "jon@example.com", "correct horse"); err != ErrTooManyAttempts {
+		t.Fatalf("locked out IP: err = %v, want ErrTooManyAttempts", err)
+	}
+	if _, err := us.AuthenticateFrom("10.0.0.2", "jon@example.com", "correct horse"); err != nil {
+		t.Fatalf("other IP: err = %v, want nil", err)
+	}
+}
+
+func TestWithAttemptStore(t *testing.T) {
+	kr, err := hash.NewKeyring("1", map[string]string{"1": "test-hmac-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryAttemptStore()
+	s, err := NewServices(
+		WithAttemptStore(store),
+		WithUser(testPepper, hash.NewBcrypt(bcrypt.MinCost), kr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := s.User.(*userService)
+	if us.throttle.store != store {
+		t.Errorf("WithUser didn't use the store passed to WithAttemptStore")
+	}
+}
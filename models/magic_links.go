@@ -0,0 +1,204 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+// magicLinkDuration is how long a login link stays valid once
+// it has been emailed.
+const magicLinkDuration = 15 * time.Minute
+
+// InitiateMagicLogin creates a login link for the user with
+// the provided email and returns them along with its token.
+// Any link issued to them before stops working.
+func (us *userService) InitiateMagicLogin(email string) (*User, string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	if user.Disabled() {
+		return nil, "", ErrAccountDisabled
+	}
+	if err := us.magicLinkDB.DeleteByUserID(user.ID); err != nil {
+		return nil, "", err
+	}
+	ml := magicLink{
+		UserID: user.ID,
+	}
+	if err := us.magicLinkDB.Create(&ml); err != nil {
+		return nil, "", err
+	}
+	return user, ml.Token, nil
+}
+
+// CompleteMagicLogin uses up the login link with the provided
+// token and returns the user it was issued to. As the link was
+// emailed to them, following it also verifies their address.
+func (us *userService) CompleteMagicLogin(token string) (*User, error) {
+	// links are single use, even if they turn out to be expired
+	ml, err := us.magicLinkDB.Use(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if ml.expired(us.now()) {
+		return nil, ErrTokenInvalid
+	}
+	user, err := us.ByID(ml.UserID)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	if !user.Verified() {
+		now := us.now()
+		user.EmailVerifiedAt = &now
+		if err := us.Update(user); err != nil {
+			return nil, err
+		}
+	}
+	// proving ownership of the account lifts any lockout on it
+	if err := us.throttle.reset(accountKey(user.Email)); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// magicLink is a single use token that logs a user in without
+// their password. Only the HMAC of the token is ever stored.
+type magicLink struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+}
+
+// expired reports whether the link is too old to be used
+func (ml *magicLink) expired(now time.Time) bool {
+	return now.Sub(ml.CreatedAt) > magicLinkDuration
+}
+
+type magicLinkDB interface {
+	// Use deletes the link with the provided token and returns
+	// it, or returns ErrNotFound if there is no such link. Only
+	// one of any number of concurrent calls can succeed.
+	Use(token string) (*magicLink, error)
+	Create(ml *magicLink) error
+	DeleteByUserID(userID uint) error
+}
+
+func newMagicLinkValidator(db magicLinkDB, hmac *hash.Keyring) *magicLinkValidator {
+	return &magicLinkValidator{
+		magicLinkDB: db,
+		hmac:        hmac,
+	}
+}
+
+type magicLinkValidator struct {
+	magicLinkDB
+	hmac *hash.Keyring
+}
+
+// Use will hash the provided token with each of our HMAC keys,
+// newest first, and call Use on the magicLinkDB field until a
+// link is found
+func (mlv *magicLinkValidator) Use(token string) (*magicLink, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	for _, tokenHash := range mlv.hmac.Candidates(token) {
+		ml, err := mlv.magicLinkDB.Use(tokenHash)
+		if err != ErrNotFound {
+			return ml, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (mlv *magicLinkValidator) Create(ml *magicLink) error {
+	err := runMagicLinkValFns(ml,
+		mlv.requireUserID,
+		mlv.setTokenIfUnset,
+		mlv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return mlv.magicLinkDB.Create(ml)
+}
+
+func (mlv *magicLinkValidator) requireUserID(ml *magicLink) error {
+	if ml.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (mlv *magicLinkValidator) setTokenIfUnset(ml *magicLink) error {
+	if ml.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	ml.Token = token
+	return nil
+}
+
+func (mlv *magicLinkValidator) hmacToken(ml *magicLink) error {
+	if ml.Token == "" {
+		return nil
+	}
+	ml.TokenHash = mlv.hmac.Hash(ml.Token)
+	return nil
+}
+
+type magicLinkValFn func(*magicLink) error
+
+func runMagicLinkValFns(ml *magicLink, fns ...magicLinkValFn) error {
+	for _, fn := range fns {
+		if err := fn(ml); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ magicLinkDB = &magicLinkGorm{}
+
+type magicLinkGorm struct {
+	db *gorm.DB
+}
+
+// Use deletes and returns the link in a single statement, so
+// two requests racing with the same token can't both get it
+func (mlg *magicLinkGorm) Use(tokenHash string) (*magicLink, error) {
+	var ml magicLink
+	db := mlg.db.Raw(`DELETE FROM magic_links WHERE token_hash = ? RETURNING *`, tokenHash).Scan(&ml)
+	if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+		return nil, db.Error
+	}
+	if db.RowsAffected != 1 {
+		return nil, ErrNotFound
+	}
+	return &ml, nil
+}
+
+func (mlg *magicLinkGorm) Create(ml *magicLink) error {
+	return mlg.db.Create(ml).Error
+}
+
+func (mlg *magicLinkGorm) DeleteByUserID(userID uint) error {
+	return mlg.db.Unscoped().Where("user_id = ?", userID).Delete(&magicLink{}).Error
+}
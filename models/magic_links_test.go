@@ -0,0 +1,164 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryMagicLinkDB is a magicLinkDB for tests
+type memoryMagicLinkDB struct {
+	mu    sync.Mutex
+	clock *testClock
+	links map[string]magicLink
+}
+
+var _ magicLinkDB = &memoryMagicLinkDB{}
+
+func newMemoryMagicLinkDB(clock *testClock) *memoryMagicLinkDB {
+	return &memoryMagicLinkDB{clock: clock, links: make(map[string]magicLink)}
+}
+
+func (db *memoryMagicLinkDB) Use(tokenHash string) (*magicLink, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ml, ok := db.links[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(db.links, tokenHash)
+	return &ml, nil
+}
+
+func (db *memoryMagicLinkDB) Create(ml *magicLink) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ml.CreatedAt = db.clock.now()
+	db.links[ml.TokenHash] = *ml
+	return nil
+}
+
+func (db *memoryMagicLinkDB) DeleteByUserID(userID uint) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for tokenHash, ml := range db.links {
+		if ml.UserID == userID {
+			delete(db.links, tokenHash)
+		}
+	}
+	return nil
+}
+
+func TestCompleteMagicLogin(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	user := addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	_, token, err := us.InitiateMagicLogin("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := us.CompleteMagicLogin(token)
+	if err != nil {
+		t.Fatalf("CompleteMagicLogin() err = %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("logged in as user %d, want %d", got.ID, user.ID)
+	}
+	if !got.Verified() {
+		t.Errorf("following the link didn't verify the email address")
+	}
+	if _, err := us.CompleteMagicLogin(token); err != ErrTokenInvalid {
+		t.Errorf("using a link twice: err = %v, want ErrTokenInvalid", err)
+	}
+	if _, err := us.CompleteMagicLogin(""); err != ErrTokenInvalid {
+		t.Errorf("empty token: err = %v, want ErrTokenInvalid", err)
+	}
+}
+
+func TestInitiateMagicLoginReturnsStoredAddress(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	user, _, err := us.InitiateMagicLogin(" Jon@Example.COM ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "jon@example.com" {
+		t.Errorf("Email = %q, want the stored address", user.Email)
+	}
+}
+
+func TestCompleteMagicLoginExpired(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	_, token, err := us.InitiateMagicLogin("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(magicLinkDuration + time.Second)
+	if _, err := us.CompleteMagicLogin(token); err != ErrTokenInvalid {
+		t.Errorf("expired link: err = %v, want ErrTokenInvalid", err)
+	}
+}
+
+func TestInitiateMagicLoginReplacesLinks(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	_, first, err := us.InitiateMagicLogin("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := us.InitiateMagicLogin("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.CompleteMagicLogin(first); err != ErrTokenInvalid {
+		t.Errorf("replaced link: err = %v, want ErrTokenInvalid", err)
+	}
+	if _, err := us.CompleteMagicLogin(second); err != nil {
+		t.Errorf("newest link: err = %v", err)
+	}
+}
+
+func TestCompleteMagicLoginConcurrent(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+	_, token, err := us.InitiateMagicLogin("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	results := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := us.CompleteMagicLogin(token)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	var ok int
+	for err := range results {
+		switch err {
+		case nil:
+			ok++
+		case ErrTokenInvalid:
+		default:
+			t.Errorf("CompleteMagicLogin() err = %v", err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d of %d concurrent uses of a link succeeded, want 1", ok, n)
+	}
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+// pwResetDuration is how long a password reset token stays
+// valid once it has been issued.
+const pwResetDuration = 1 * time.Hour
+
+// pwReset is a single use token that lets a user choose a new
+// password. Only the HMAC of the token is ever stored.
+type pwReset struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+}
+
+// expired reports whether the reset token is too old to be used
+func (pwr *pwReset) expired(now time.Time) bool {
+	return now.Sub(pwr.CreatedAt) > pwResetDuration
+}
+
+type pwResetDB interface {
+	// Use deletes the reset with the provided token and returns
+	// it, or returns ErrNotFound if there is no such reset. Only
+	// one of any number of concurrent calls can succeed.
+	Use(token string) (*pwReset, error)
+	Create(pwr *pwReset) error
+	DeleteByUserID(userID uint) error
+}
+
+func newPwResetValidator(db pwResetDB, hmac *hash.Keyring) *pwResetValidator {
+	return &pwResetValidator{
+		pwResetDB: db,
+		hmac:      hmac,
+	}
+}
+
+type pwResetValidator struct {
+	pwResetDB
+	hmac *hash.Keyring
+}
+
+// Use will hash the provided token with each of our HMAC keys,
+// newest first, and call Use on the pwResetDB field until a
+// reset is found
+func (pwrv *pwResetValidator) Use(token string) (*pwReset, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	for _, tokenHash := range pwrv.hmac.Candidates(token) {
+		pwr, err := pwrv.pwResetDB.Use(tokenHash)
+		if err != ErrNotFound {
+			return pwr, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
+	err := runPwResetValFns(pwr,
+		pwrv.requireUserID,
+		pwrv.setTokenIfUnset,
+		pwrv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return pwrv.pwResetDB.Create(pwr)
+}
+
+func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
+	if pwr.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
+	if pwr.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	pwr.Token = token
+	return nil
+}
+
+func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
+	if pwr.Token == "" {
+		return nil
+	}
+	pwr.TokenHash = pwrv.hmac.Hash(pwr.Token)
+	return nil
+}
+
+type pwResetValFn func(*pwReset) error
+
+func runPwResetValFns(pwr *pwReset, fns ...pwResetValFn) error {
+	for _, fn := range fns {
+		if err := fn(pwr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ pwResetDB = &pwResetGorm{}
+
+type pwResetGorm struct {
+	db *gorm.DB
+}
+
+// Use deletes and returns the reset in a single statement, so
+// two requests racing with the same token can't both get it
+func (pwrg *pwResetGorm) Use(tokenHash string) (*pwReset, error) {
+	var pwr pwReset
+	db := pwrg.db.Raw(`DELETE FROM pw_resets WHERE token_hash = ? RETURNING *`, tokenHash).Scan(&pwr)
+	if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+		return nil, db.Error
+	}
+	if db.RowsAffected != 1 {
+		return nil, ErrNotFound
+	}
+	return &pwr, nil
+}
+
+func (pwrg *pwResetGorm) Create(pwr *pwReset) error {
+	return pwrg.db.Create(pwr).Error
+}
+
+func (pwrg *pwResetGorm) DeleteByUserID(userID uint) error {
+	return pwrg.db.Unscoped().Where("user_id = ?", userID).Delete(&pwReset{}).Error
+}
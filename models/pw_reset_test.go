@@ -0,0 +1,184 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryPwResetDB is a pwResetDB for tests
+type memoryPwResetDB struct {
+	mu     sync.Mutex
+	clock  *testClock
+	resets map[string]pwReset
+}
+
+var _ pwResetDB = &memoryPwResetDB{}
+
+func newMemoryPwResetDB(clock *testClock) *memoryPwResetDB {
+	return &memoryPwResetDB{clock: clock, resets: make(map[string]pwReset)}
+}
+
+func (db *memoryPwResetDB) Use(tokenHash string) (*pwReset, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	pwr, ok := db.resets[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(db.resets, tokenHash)
+	return &pwr, nil
+}
+
+func (db *memoryPwResetDB) Create(pwr *pwReset) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	pwr.CreatedAt = db.clock.now()
+	db.resets[pwr.TokenHash] = *pwr
+	return nil
+}
+
+func (db *memoryPwResetDB) DeleteByUserID(userID uint) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for tokenHash, pwr := range db.resets {
+		if pwr.UserID == userID {
+			delete(db.resets, tokenHash)
+		}
+	}
+	return nil
+}
+
+func TestCompleteReset(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	user := addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	_, token, err := us.InitiateReset("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := us.CompleteReset(token, "battery staple")
+	if err != nil {
+		t.Fatalf("CompleteReset() err = %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("reset the password of user %d, want %d", got.ID, user.ID)
+	}
+	if _, err := us.Authenticate("jon@example.com", "battery staple"); err != nil {
+		t.Errorf("logging in with the new password: err = %v", err)
+	}
+	if _, err := us.CompleteReset(token, "another password"); err != ErrTokenInvalid {
+		t.Errorf("using a token twice: err = %v, want ErrTokenInvalid", err)
+	}
+	if _, err := us.CompleteReset("", "another password"); err != ErrTokenInvalid {
+		t.Errorf("empty token: err = %v, want ErrTokenInvalid", err)
+	}
+}
+
+func TestInitiateResetReturnsStoredAddress(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	user, _, err := us.InitiateReset(" Jon@Example.COM ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "jon@example.com" {
+		t.Errorf("Email = %q, want the stored address", user.Email)
+	}
+}
+
+func TestCompleteResetExpired(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	_, token, err := us.InitiateReset("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(pwResetDuration + time.Second)
+	if _, err := us.CompleteReset(token, "battery staple"); err != ErrTokenInvalid {
+		t.Errorf("expired token: err = %v, want ErrTokenInvalid", err)
+	}
+}
+
+func TestCompleteResetKeepsTokenForBadPassword(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	_, token, err := us.InitiateReset("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.CompleteReset(token, ""); err != ErrPasswordRequired {
+		t.Errorf("empty password: err = %v, want ErrPasswordRequired", err)
+	}
+	if _, err := us.CompleteReset(token, "abc"); err != ErrPasswordTooShort {
+		t.Errorf("short password: err = %v, want ErrPasswordTooShort", err)
+	}
+	if _, err := us.CompleteReset(token, "battery staple"); err != nil {
+		t.Errorf("token after rejected passwords: err = %v", err)
+	}
+}
+
+func TestInitiateResetReplacesTokens(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+
+	_, first, err := us.InitiateReset("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := us.InitiateReset("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.CompleteReset(first, "battery staple"); err != ErrTokenInvalid {
+		t.Errorf("replaced token: err = %v, want ErrTokenInvalid", err)
+	}
+	if _, err := us.CompleteReset(second, "battery staple"); err != nil {
+		t.Errorf("newest token: err = %v", err)
+	}
+}
+
+func TestCompleteResetConcurrent(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	addTestUser(t, us, db, "jon@example.com", "correct horse")
+	_, token, err := us.InitiateReset("jon@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	results := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := us.CompleteReset(token, "battery staple")
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	var ok int
+	for err := range results {
+		switch err {
+		case nil:
+			ok++
+		case ErrTokenInvalid:
+		default:
+			t.Errorf("CompleteReset() err = %v", err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d of %d concurrent uses of a token succeeded, want 1", ok, n)
+	}
+}
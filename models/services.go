@@ -1,24 +1,151 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"errors"
 
-func NewServices(connectionInfo string) (*Services, error) {
-	db, err := gorm.Open("postgres", connectionInfo)
-	if err != nil {
-		return nil, err
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/hash"
+	"lenslocked.com/jobs"
+	"lenslocked.com/storage"
+)
+
+// ServicesConfig is used to set up the Services returned by
+// NewServices. They are applied in the order provided, so
+// WithGorm has to come first.
+type ServicesConfig func(*Services) error
+
+// WithGorm opens the database all of our services use
+func WithGorm(dialect, connectionInfo string) ServicesConfig {
+	return func(s *Services) error {
+		db, err := gorm.Open(dialect, connectionInfo)
+		if err != nil {
+			return err
+		}
+		s.db = db
+		return nil
+	}
+}
+
+func WithLogMode(mode bool) ServicesConfig {
+	return func(s *Services) error {
+		s.db.LogMode(mode)
+		return nil
+	}
+}
+
+// WithJobs sets up the job queue, which keeps its jobs in the
+// database. Services that run work in the background need it,
+// so it has to come before them.
+func WithJobs(cfg jobs.Config) ServicesConfig {
+	return func(s *Services) error {
+		s.Jobs = jobs.NewQueue(NewJobStore(s.db), cfg)
+		s.Jobs.Handle(s.handlePurgeDeletedUsers)
+		return nil
+	}
+}
+
+// WithAttemptStore replaces the database as the place failed
+// logins are recorded. It has to come before WithUser.
+func WithAttemptStore(attempts AttemptStore) ServicesConfig {
+	return func(s *Services) error {
+		s.attempts = attempts
+		return nil
+	}
+}
+
+// WithUser sets up the UserService. Passwords are combined
+// with pepper and then hashed with hasher, while tokens are
+// hashed with the keys in hmac.
+func WithUser(pepper string, hasher hash.PasswordHasher, hmac *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		s.User = NewUserService(s.db, pepper, hasher, hmac, s.attempts)
+		return nil
+	}
+}
+
+func WithSession(hmac *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		s.Session = NewSessionService(s.db, hmac)
+		return nil
+	}
+}
+
+// WithGallery sets up the GalleryService. Deleting a gallery
+// also deletes its images in the background, so WithJobs and
+// WithImage have to come first.
+func WithGallery() ServicesConfig {
+	return func(s *Services) error {
+		if s.Jobs == nil || s.Image == nil {
+			return errors.New("models: WithJobs and WithImage have to come before WithGallery")
+		}
+		s.Gallery = NewGalleryService(s.db, s.Image, s.Jobs)
+		return nil
+	}
+}
+
+// WithImage sets up the ImageService, which keeps images in
+// blob, rejects files over maxSize bytes and makes a variant of
+// every image for each of the presets. Variants are made in the
+// background, so WithJobs has to come first.
+func WithImage(blob storage.Blob, maxSize int64, presets []VariantPreset) ServicesConfig {
+	return func(s *Services) error {
+		if s.Jobs == nil {
+			return errors.New("models: WithJobs has to come before WithImage")
+		}
+		if err := validPresets(presets); err != nil {
+			return err
+		}
+		s.Image = NewImageService(blob, maxSize, presets, s.Jobs)
+		return nil
+	}
+}
+
+// WithAPIToken sets up the APITokenService. Tokens are
+// hashed with the keys in hmac.
+func WithAPIToken(hmac *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		s.APIToken = NewAPITokenService(s.db, hmac)
+		return nil
+	}
+}
+
+// WithInvitation sets up the InvitationService. Invitation
+// codes are hashed with the keys in hmac.
+func WithInvitation(hmac *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		s.Invitation = NewInvitationService(s.db, hmac)
+		return nil
 	}
-	db.LogMode(true)
-	return &Services{
-		User: NewUserService(db),
-		Gallery: NewGalleryService(db),
-		db: db,
-	}, nil
+}
+
+func WithAudit() ServicesConfig {
+	return func(s *Services) error {
+		s.Audit = NewAuditService(s.db)
+		return nil
+	}
+}
+
+func NewServices(cfgs ...ServicesConfig) (*Services, error) {
+	var s Services
+	for _, cfg := range cfgs {
+		if err := cfg(&s); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
 }
 
 type Services struct {
-	Gallery GalleryService
-	User    UserService
-	db      *gorm.DB
+	Gallery    GalleryService
+	Image      ImageService
+	User       UserService
+	Session    SessionService
+	Audit      AuditService
+	APIToken   APITokenService
+	Invitation InvitationService
+	Jobs       *jobs.Queue
+	db         *gorm.DB
+	attempts   AttemptStore
 }
 
 // Closes the database connection
@@ -28,7 +155,7 @@ func (s *Services) Close() error {
 
 // DestructiveReset drops the all tables and rebuilds them
 func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Gallery{}).Error
+	err := s.db.DropTableIfExists(&User{}, &Gallery{}, &pwReset{}, &LoginAttempt{}, &Session{}, &recoveryCode{}, &AuditEntry{}, &APIToken{}, &magicLink{}, &Invitation{}, &jobs.Job{}).Error
 	if err != nil {
 		return err
 	}
@@ -37,5 +164,14 @@ func (s *Services) DestructiveReset() error {
 
 // Automigrate will attempt to automatically migrate all tables
 func (s *Services) AutoMigrate() error {
-	return  s.db.AutoMigrate(&User{}, &Gallery{}).Error
+	err := s.db.AutoMigrate(&User{}, &Gallery{}, &pwReset{}, &LoginAttempt{}, &Session{}, &recoveryCode{}, &AuditEntry{}, &APIToken{}, &magicLink{}, &Invitation{}, &jobs.Job{}).Error
+	if err != nil {
+		return err
+	}
+	// remember tokens used to live on the users table, one per
+	// user, before they moved to sessions
+	if s.db.Dialect().HasColumn("users", "remember_hash") {
+		return s.db.Model(&User{}).DropColumn("remember_hash").Error
+	}
+	return nil
 }
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+// sessionDuration is how long a session lasts before the user
+// has to log in again
+const sessionDuration = 30 * 24 * time.Hour
+
+// ImpersonationDuration is how long an admin can act as
+// another user before having to start over
+const ImpersonationDuration = 30 * time.Minute
+
+// Session represents a single logged in device or browser.
+// A user can have any number of sessions at once, and each
+// one can be revoked on its own.
+type Session struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;unique_index"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"index"`
+	// Persistent sessions were started with "remember me"
+	// ticked, and their cookie outlives the browser
+	Persistent bool
+	// ImpersonatorID is set when an admin is using the session
+	// to act as UserID
+	ImpersonatorID uint
+}
+
+// Impersonated reports whether an admin is acting as the
+// session's user
+func (s *Session) Impersonated() bool {
+	return s.ImpersonatorID != 0
+}
+
+// Expired reports whether the session can no longer be used
+func (s *Session) Expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+// SessionDB is used to interact with the sessions database.
+type SessionDB interface {
+	ByID(id uint) (*Session, error)
+	// ByRemember looks up an unexpired session by the raw
+	// token stored in the user's remember_token cookie.
+	ByRemember(token string) (*Session, error)
+	// ByUserID returns every unexpired session of the user,
+	// most recently used first.
+	ByUserID(userID uint) ([]Session, error)
+
+	Create(session *Session) error
+	Update(session *Session) error
+	Delete(id uint) error
+	// DeleteByUserID revokes every session the user has.
+	DeleteByUserID(userID uint) error
+}
+
+// SessionService is a set of methods used to manipulate and
+// work with the session model
+type SessionService interface {
+	SessionDB
+}
+
+func NewSessionService(db *gorm.DB, hmac *hash.Keyring) SessionService {
+	return &sessionService{
+		SessionDB: newSessionValidator(&sessionGorm{db}, hmac),
+	}
+}
+
+var _ SessionService = &sessionService{}
+
+type sessionService struct {
+	SessionDB
+}
+
+type sessionValidatorFunc func(*Session) error
+
+func runSessionValidatorFunc(session *Session, fns ...sessionValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionValidator{}
+
+func newSessionValidator(sdb SessionDB, hmac *hash.Keyring) *sessionValidator {
+	return &sessionValidator{
+		SessionDB: sdb,
+		hmac:      hmac,
+	}
+}
+
+type sessionValidator struct {
+	SessionDB
+	hmac *hash.Keyring
+}
+
+// ByRemember will hash the token with each of our HMAC keys,
+// newest first, and call ByRemember on the SessionDB field
+// until a session is found. Sessions found with an older key
+// are re-hashed with the primary key so the older key can
+// eventually be retired.
+func (sv *sessionValidator) ByRemember(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	for _, tokenHash := range sv.hmac.Candidates(token) {
+		session, err := sv.SessionDB.ByRemember(tokenHash)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !sv.hmac.IsPrimary(session.TokenHash) {
+			session.Token = token
+			if err := sv.Update(session); err != nil {
+				return nil, err
+			}
+		}
+		return session, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Create will generate a token for the session if one isn't
+// provided, and backfill data like the ID and ExpiresAt.
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValidatorFunc(session,
+		sv.userIDRequired,
+		sv.setTokenIfUnset,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired,
+		sv.setTimesIfUnset)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Create(session)
+}
+
+func (sv *sessionValidator) Update(session *Session) error {
+	err := runSessionValidatorFunc(session,
+		sv.userIDRequired,
+		sv.idGreaterThan(0),
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Update(session)
+}
+
+func (sv *sessionValidator) Delete(id uint) error {
+	var session Session
+	session.ID = id
+	err := runSessionValidatorFunc(&session, sv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Delete(id)
+}
+
+func (sv *sessionValidator) DeleteByUserID(userID uint) error {
+	session := Session{
+		UserID: userID,
+	}
+	err := runSessionValidatorFunc(&session, sv.userIDRequired)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.DeleteByUserID(userID)
+}
+
+func (sv *sessionValidator) userIDRequired(session *Session) error {
+	if session.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) idGreaterThan(n uint) sessionValidatorFunc {
+	return sessionValidatorFunc(func(session *Session) error {
+		if session.ID <= n {
+			return ErrIDInvalid
+		}
+		return nil
+	})
+}
+
+func (sv *sessionValidator) setTokenIfUnset(session *Session) error {
+	if session.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	session.Token = token
+	return nil
+}
+
+func (sv *sessionValidator) tokenMinBytes(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	n, err := rand.NBytes(session.Token)
+	if err != nil {
+		return err
+	}
+	if n < rand.RememberTokenBytes {
+		return ErrRememberTooShort
+	}
+	return nil
+}
+
+func (sv *sessionValidator) hmacToken(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	session.TokenHash = sv.hmac.Hash(session.Token)
+	return nil
+}
+
+func (sv *sessionValidator) tokenHashRequired(session *Session) error {
+	if session.TokenHash == "" {
+		return ErrRememberRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTimesIfUnset(session *Session) error {
+	now := time.Now()
+	if session.LastSeenAt.IsZero() {
+		session.LastSeenAt = now
+	}
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = now.Add(sessionDuration)
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionGorm{}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+func (sg *sessionGorm) ByID(id uint) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("id = ?", id), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) ByRemember(tokenHash string) (*Session, error) {
+	var session Session
+	db := sg.db.Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now())
+	err := first(db, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) ByUserID(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) Update(session *Session) error {
+	return sg.db.Save(session).Error
+}
+
+// Delete permanently removes the session so its token can
+// never be used again
+func (sg *sessionGorm) Delete(id uint) error {
+	session := Session{Model: gorm.Model{ID: id}}
+	return sg.db.Unscoped().Delete(&session).Error
+}
+
+func (sg *sessionGorm) DeleteByUserID(userID uint) error {
+	return sg.db.Unscoped().Where("user_id = ?", userID).Delete(&Session{}).Error
+}
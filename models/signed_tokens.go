@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenSep separates the fields of a signed token. None of
+// the values we sign (IDs, emails, timestamps) can contain it.
+const tokenSep = "|"
+
+// signToken returns a token carrying fields and an expiry,
+// signed with our HMAC key so it can't be forged or tampered
+// with. Signed tokens are never stored; the first field should
+// name what the token is for so one kind of token can't be
+// used in place of another.
+func (us *userService) signToken(ttl time.Duration, fields ...string) string {
+	expiresAt := strconv.FormatInt(us.now().Add(ttl).Unix(), 10)
+	payload := strings.Join(append(fields, expiresAt), tokenSep)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + us.hmac.Hash(payload)
+}
+
+// parseToken checks the signature and expiry of a token made
+// by signToken and returns its fields. ErrTokenInvalid is
+// returned if the token can't be trusted for any reason.
+func (us *userService) parseToken(token string) ([]string, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrTokenInvalid
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	payload := string(b)
+	if !us.hmac.Verify(payload, parts[1]) {
+		return nil, ErrTokenInvalid
+	}
+	fields := strings.Split(payload, tokenSep)
+	expiresAt, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if us.now().After(time.Unix(expiresAt, 0)) {
+		return nil, ErrTokenInvalid
+	}
+	return fields[:len(fields)-1], nil
+}
+
+// parseUserToken parses a signed token of the provided kind
+// whose second field is a user ID, and returns the ID along
+// with any remaining fields.
+func (us *userService) parseUserToken(token, kind string) (uint, []string, error) {
+	fields, err := us.parseToken(token)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(fields) < 2 || fields[0] != kind {
+		return 0, nil, ErrTokenInvalid
+	}
+	id, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, nil, ErrTokenInvalid
+	}
+	return uint(id), fields[2:], nil
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"lenslocked.com/rand"
+	"lenslocked.com/totp"
+)
+
+const (
+	totpIssuer = "Lenslocked"
+	// totpSkew is how many 30 second steps of clock drift we
+	// tolerate between the server and the user's device
+	totpSkew = 1
+	// recoveryCodeCount is how many recovery codes are issued
+	// when two factor authentication is enabled
+	recoveryCodeCount = 10
+	// twoFactorChallengeDuration is how long a user has to enter
+	// their code after entering a correct password
+	twoFactorChallengeDuration = 5 * time.Minute
+	twoFactorTokenKind         = "2fa"
+)
+
+// TwoFactorEnabled reports whether the user has to provide a
+// one-time code in addition to their password to log in
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// recoveryEncoding keeps recovery codes free of easily
+// confused characters like 0/O and 1/l
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// recoveryCode is a single use code that can be entered in
+// place of a TOTP code if the user loses their device. Only
+// the HMAC of the code is ever stored.
+type recoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	CodeHash string `gorm:"not null;unique_index"`
+}
+
+type recoveryCodeDB interface {
+	// Use deletes the user's code with the provided hash and
+	// returns ErrNotFound if there is no such code
+	Use(userID uint, codeHash string) error
+	Create(code *recoveryCode) error
+	DeleteByUserID(userID uint) error
+}
+
+var _ recoveryCodeDB = &recoveryCodeGorm{}
+
+type recoveryCodeGorm struct {
+	db *gorm.DB
+}
+
+func (rcg *recoveryCodeGorm) Use(userID uint, codeHash string) error {
+	db := rcg.db.Unscoped().Where("user_id = ? AND code_hash = ?", userID, codeHash).Delete(&recoveryCode{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (rcg *recoveryCodeGorm) Create(code *recoveryCode) error {
+	return rcg.db.Create(code).Error
+}
+
+func (rcg *recoveryCodeGorm) DeleteByUserID(userID uint) error {
+	return rcg.db.Unscoped().Where("user_id = ?", userID).Delete(&recoveryCode{}).Error
+}
+
+// BeginTOTP generates a TOTP secret for the user, unless they
+// already have one waiting to be confirmed, and returns the
+// otpauth URI to enroll it in an authenticator app. Two factor
+// authentication isn't enabled until EnableTOTP is called
+// with a valid code.
+func (us *userService) BeginTOTP(user *User) (string, error) {
+	if user.TwoFactorEnabled() {
+		return "", ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		secret, err := totp.NewSecret()
+		if err != nil {
+			return "", err
+		}
+		user.TOTPSecret = secret
+		if err := us.Update(user); err != nil {
+			return "", err
+		}
+	}
+	return totp.URI(totpIssuer, user.Email, user.TOTPSecret), nil
+}
+
+// EnableTOTP confirms the user's authenticator app works by
+// checking code, then enables two factor authentication and
+// returns a fresh set of recovery codes. The codes are only
+// ever available here, so they must be shown to the user.
+func (us *userService) EnableTOTP(user *User, code string) ([]string, error) {
+	if user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPInvalid
+	}
+	now := us.now()
+	step, ok := totp.Validate(code, user.TOTPSecret, now, totpSkew)
+	if !ok {
+		return nil, ErrTOTPInvalid
+	}
+	codes, err := us.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPEnabledAt = &now
+	user.TOTPLastStep = step
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two factor authentication off and throws
+// away the user's secret and recovery codes
+func (us *userService) DisableTOTP(user *User) error {
+	if err := us.recoveryDB.DeleteByUserID(user.ID); err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	return us.Update(user)
+}
+
+// TwoFactorChallenge returns a short lived signed token that
+// records the user got their password right. It has to be
+// presented along with a code to VerifyTwoFactor.
+func (us *userService) TwoFactorChallenge(user *User) string {
+	return us.signToken(twoFactorChallengeDuration, twoFactorTokenKind, fmt.Sprint(user.ID))
+}
+
+// VerifyTwoFactor completes a login started with a correct
+// password. code can be either a TOTP code or one of the
+// user's unused recovery codes. Failures count towards the
+// same lockout as failed passwords.
+func (us *userService) VerifyTwoFactor(challenge, code string) (*User, error) {
+	userID, _, err := us.parseUserToken(challenge, twoFactorTokenKind)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.ByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TwoFactorEnabled() {
+		return nil, ErrTokenInvalid
+	}
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	key := accountKey(user.Email)
+	if err := us.throttle.allow(key, accountThrottle); err != nil {
+		return nil, err
+	}
+
+	err = us.checkTwoFactor(user, code)
+	switch err {
+	case nil:
+		return user, us.throttle.reset(key)
+	case ErrTOTPInvalid:
+		if err := us.throttle.fail(key); err != nil {
+			return nil, err
+		}
+		return nil, ErrTOTPInvalid
+	default:
+		return nil, err
+	}
+}
+
+func (us *userService) checkTwoFactor(user *User, code string) error {
+	code = strings.TrimSpace(code)
+	step, ok := totp.Validate(code, user.TOTPSecret, us.now(), totpSkew)
+	if ok {
+		// every code can only be used once
+		if step <= user.TOTPLastStep {
+			return ErrTOTPInvalid
+		}
+		user.TOTPLastStep = step
+		return us.Update(user)
+	}
+
+	for _, codeHash := range us.hmac.Candidates(normalizeRecoveryCode(code)) {
+		err := us.recoveryDB.Use(user.ID, codeHash)
+		if err != ErrNotFound {
+			return err
+		}
+	}
+	return ErrTOTPInvalid
+}
+
+func (us *userService) newRecoveryCodes(userID uint) ([]string, error) {
+	if err := us.recoveryDB.DeleteByUserID(userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b, err := rand.Bytes(5)
+		if err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes[i] = raw[:4] + "-" + raw[4:]
+		rc := recoveryCode{
+			UserID:   userID,
+			CodeHash: us.hmac.Hash(normalizeRecoveryCode(codes[i])),
+		}
+		if err := us.recoveryDB.Create(&rc); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes with or
+// without the dash and in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+	return strings.ToLower(code)
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"lenslocked.com/totp"
+)
+
+// newTwoFactorUser returns a user with two factor
+// authentication enabled, along with their recovery codes
+func newTwoFactorUser(t *testing.T, us *userService, db *memoryUserDB) (*User, []string) {
+	t.Helper()
+	user := addTestUser(t, us, db, "jon@example.com", "correct horse")
+	if _, err := us.BeginTOTP(user); err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(user.TOTPSecret, us.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := us.EnableTOTP(user, code)
+	if err != nil {
+		t.Fatalf("EnableTOTP() err = %v", err)
+	}
+	return user, codes
+}
+
+func TestCheckTwoFactorTOTP(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	user, _ := newTwoFactorUser(t, us, db)
+	codeAt := func(at time.Time) string {
+		code, err := totp.Code(user.TOTPSecret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	// the code used to enable 2FA can't be used again
+	if err := us.checkTwoFactor(user, codeAt(clock.now())); err != ErrTOTPInvalid {
+		t.Errorf("replaying the enrollment code: err = %v, want ErrTOTPInvalid", err)
+	}
+
+	clock.advance(totp.Period)
+	code := codeAt(clock.now())
+	if err := us.checkTwoFactor(user, code); err != nil {
+		t.Fatalf("checkTwoFactor() err = %v", err)
+	}
+	stored, _ := db.ByID(user.ID)
+	if want := totp.Step(clock.now()); stored.TOTPLastStep != want {
+		t.Errorf("stored TOTPLastStep = %d, want %d", stored.TOTPLastStep, want)
+	}
+	if err := us.checkTwoFactor(user, code); err != ErrTOTPInvalid {
+		t.Errorf("replaying a code: err = %v, want ErrTOTPInvalid", err)
+	}
+
+	// a code from the next step is accepted early, but once it
+	// is used the current step's code is too old
+	clock.advance(totp.Period)
+	current := codeAt(clock.now())
+	if err := us.checkTwoFactor(user, codeAt(clock.now().Add(totp.Period))); err != nil {
+		t.Fatalf("code from the next step: err = %v", err)
+	}
+	if err := us.checkTwoFactor(user, current); err != ErrTOTPInvalid {
+		t.Errorf("code older than the last one used: err = %v, want ErrTOTPInvalid", err)
+	}
+
+	// codes more than totpSkew steps away are refused
+	clock.advance(10 * totp.Period)
+	if err := us.checkTwoFactor(user, codeAt(clock.now().Add(-2*totp.Period))); err != ErrTOTPInvalid {
+		t.Errorf("code two steps old: err = %v, want ErrTOTPInvalid", err)
+	}
+	if err := us.checkTwoFactor(user, codeAt(clock.now().Add(2*totp.Period))); err != ErrTOTPInvalid {
+		t.Errorf("code two steps ahead: err = %v, want ErrTOTPInvalid", err)
+	}
+}
+
+func TestCheckTwoFactorRecoveryCodes(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	user, codes := newTwoFactorUser(t, us, db)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if seen[code] {
+			t.Fatalf("recovery code %q issued twice", code)
+		}
+		seen[code] = true
+	}
+
+	if err := us.checkTwoFactor(user, codes[0]); err != nil {
+		t.Fatalf("recovery code: err = %v", err)
+	}
+	if err := us.checkTwoFactor(user, codes[0]); err != ErrTOTPInvalid {
+		t.Errorf("reusing a recovery code: err = %v, want ErrTOTPInvalid", err)
+	}
+
+	// codes can be typed without the dash, in any case
+	typed := " " + strings.ToUpper(codes[1][:4]+codes[1][5:]) + " "
+	if err := us.checkTwoFactor(user, typed); err != nil {
+		t.Errorf("recovery code %q typed as %q: err = %v", codes[1], typed, err)
+	}
+
+	other := addTestUser(t, us, db, "other@example.com", "correct horse")
+	if err := us.checkTwoFactor(other, codes[2]); err != ErrTOTPInvalid {
+		t.Errorf("another user's recovery code: err = %v, want ErrTOTPInvalid", err)
+	}
+
+	// disabling 2FA throws the remaining codes away
+	if err := us.DisableTOTP(user); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.recoveryDB.Use(user.ID, us.hmac.Hash(normalizeRecoveryCode(codes[2]))); err != ErrNotFound {
+		t.Errorf("recovery code after DisableTOTP: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestEnableTOTP(t *testing.T) {
+	clock := &testClock{t: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	us, db := newTestUserService(t, clock)
+	user := addTestUser(t, us, db, "jon@example.com", "correct horse")
+	if _, err := us.EnableTOTP(user, "123456"); err != ErrTOTPInvalid {
+		t.Errorf("EnableTOTP() before BeginTOTP: err = %v, want ErrTOTPInvalid", err)
+	}
+	if _, err := us.BeginTOTP(user); err != nil {
+		t.Fatal(err)
+	}
+	secret := user.TOTPSecret
+	if _, err := us.BeginTOTP(user); err != nil {
+		t.Fatal(err)
+	}
+	if user.TOTPSecret != secret {
+		t.Errorf("BeginTOTP() replaced a secret waiting to be confirmed")
+	}
+	wrong, _ := totp.Code(secret, clock.now().Add(-5*totp.Period))
+	if _, err := us.EnableTOTP(user, wrong); err != ErrTOTPInvalid {
+		t.Errorf("EnableTOTP() with an old code: err = %v, want ErrTOTPInvalid", err)
+	}
+	if user.TwoFactorEnabled() {
+		t.Fatalf("2FA enabled by a wrong code")
+	}
+	code, _ := totp.Code(secret, clock.now())
+	if _, err := us.EnableTOTP(user, code); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := db.ByID(user.ID)
+	if !stored.TwoFactorEnabled() {
+		t.Errorf("stored user doesn't have 2FA enabled")
+	}
+	if _, err := us.EnableTOTP(user, code); err != ErrTwoFactorEnabled {
+		t.Errorf("enabling twice: err = %v, want ErrTwoFactorEnabled", err)
+	}
+}
@@ -3,17 +3,14 @@ package models
 import (
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
-	"golang.org/x/crypto/bcrypt"
 	"lenslocked.com/hash"
 	"lenslocked.com/rand"
 )
 
-const userPwPaper = "secret-random-string"
-const hmacSecretKey = "secret-hmac-key"
-
 // User represents the user   model in our database
 type User struct {
 	gorm.Model
@@ -21,8 +18,57 @@ type User struct {
 	Email        string `gorm:"not null;unique_index"`
 	Password     string `gorm:"-"`
 	PasswordHash string `gorm:"not null"`
-	Remember     string `gorm:"-"`
-	RememberHash string `gorm:"not null;unique_index"`
+	// EmailVerifiedAt is set once the user has followed the
+	// verification link we sent to Email
+	EmailVerifiedAt *time.Time
+	// PendingEmail holds a new address the user asked to switch
+	// to. Email stays in use until the new address is verified.
+	PendingEmail string
+	// TOTPSecret is the shared secret of the user's authenticator
+	// app. Two factor authentication is only enforced once
+	// TOTPEnabledAt is set.
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	// TOTPLastStep is the time step of the last code accepted,
+	// so the same code can't be replayed
+	TOTPLastStep int64
+	// DeletionScheduledAt is set when the user asks for their
+	// account to be deleted. See AccountDeletionGrace.
+	DeletionScheduledAt *time.Time
+	// Role decides what the user is allowed to do beyond
+	// managing their own account, see RoleUser and RoleAdmin
+	Role string `gorm:"not null;default:'user'"`
+	// DisabledAt is set when an admin disables the account,
+	// along with the reason they gave
+	DisabledAt     *time.Time
+	DisabledReason string
+}
+
+const (
+	// RoleUser is the role everyone who signs up gets
+	RoleUser = "user"
+	// RoleAdmin can manage every user and gallery on the site
+	RoleAdmin = "admin"
+)
+
+// Roles lists every valid role
+var Roles = []string{RoleUser, RoleAdmin}
+
+// HasRole reports whether the user has the provided role.
+// Admins are considered to have every role.
+func (u *User) HasRole(role string) bool {
+	return u.Role == role || u.Role == RoleAdmin
+}
+
+// IsAdmin reports whether the user is an administrator
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Verified reports whether the user has confirmed they own
+// their email address
+func (u *User) Verified() bool {
+	return u.EmailVerifiedAt != nil
 }
 
 // UserDB is used to interact with the users database.
@@ -30,7 +76,9 @@ type UserDB interface {
 	// methods for querying a single user
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
-	ByRemember(token string) (*User, error)
+	// Search returns users whose name or email contains query,
+	// newest first. An empty query matches every user.
+	Search(query string, limit, offset int) ([]User, error)
 
 	// methods for altering user
 	Create(user *User) error
@@ -43,15 +91,81 @@ type UserDB interface {
 type UserService interface {
 	// Authenticate will verify the email and password
 	Authenticate(email, password string) (*User, error)
+	// AuthenticateFrom is Authenticate for a login attempt
+	// made from the provided client IP address.
+	AuthenticateFrom(ip, email, password string) (*User, error)
+	// InitiateReset will start the password reset process for
+	// the user with the provided email address and return them
+	// along with the token that should be sent to their address.
+	InitiateReset(email string) (*User, string, error)
+	// CompleteReset will update the password of the user the
+	// token was issued to, as long as the token is still valid.
+	// A token can only ever be redeemed once.
+	CompleteReset(token, newPw string) (*User, error)
+	// VerificationToken returns a signed token to email to the
+	// user so they can prove they own their (pending) address.
+	VerificationToken(user *User) (string, error)
+	// VerifyEmail marks the address the token was issued for
+	// as verified.
+	VerifyEmail(token string) (*User, error)
+	// BeginTOTP prepares a TOTP secret for the user and returns
+	// the otpauth URI used to add it to an authenticator app.
+	BeginTOTP(user *User) (string, error)
+	// EnableTOTP turns on two factor authentication once the
+	// user proves their app works, returning recovery codes.
+	EnableTOTP(user *User, code string) ([]string, error)
+	// DisableTOTP turns off two factor authentication.
+	DisableTOTP(user *User) error
+	// TwoFactorChallenge returns a token proving the user has
+	// already provided the correct password.
+	TwoFactorChallenge(user *User) string
+	// VerifyTwoFactor checks the second factor of a login.
+	VerifyTwoFactor(challenge, code string) (*User, error)
+	// ScheduleDeletion marks the account to be purged once the
+	// grace period is over.
+	ScheduleDeletion(user *User) error
+	// CancelDeletion restores an account scheduled for deletion.
+	CancelDeletion(user *User) error
+	// InitiateMagicLogin returns the user with the provided email
+	// and a token for a single use link that logs them in.
+	InitiateMagicLogin(email string) (*User, string, error)
+	// CompleteMagicLogin uses up a login link and returns the
+	// user it logs in.
+	CompleteMagicLogin(token string) (*User, error)
+	// ForceReset replaces the user's password with a random one
+	// and returns a reset token they can use to choose another.
+	ForceReset(user *User) (string, error)
+	// Disable stops the user from logging in.
+	Disable(user *User, reason string) error
+	// Enable lets a disabled user log in again.
+	Enable(user *User) error
 	UserDB
 }
 
-func NewUserService(db *gorm.DB) UserService { 
+// NewUserService returns a UserService backed by db. Passwords
+// are combined with pepper before being hashed by hasher,
+// tokens are hashed and signed with hmac, and failed logins
+// are recorded in attempts, or in the database if attempts
+// is nil.
+func NewUserService(db *gorm.DB, pepper string, hasher hash.PasswordHasher, hmac *hash.Keyring, attempts AttemptStore) UserService {
+	if attempts == nil {
+		attempts = &attemptGorm{db}
+	}
 	ug := &userGorm{db}
-	hmac := hash.NewHMAC(hmacSecretKey)
-	uv := newUserValidator(ug, hmac)
+	uv := newUserValidator(ug, pepper, hasher)
 	return &userService{
-		UserDB: uv,
+		UserDB:      uv,
+		uv:          uv,
+		hmac:        hmac,
+		pwResetDB:   newPwResetValidator(&pwResetGorm{db}, hmac),
+		magicLinkDB: newMagicLinkValidator(&magicLinkGorm{db}, hmac),
+		recoveryDB:  &recoveryCodeGorm{db},
+		sessionDB:   newSessionValidator(&sessionGorm{db}, hmac),
+		throttle: &loginThrottle{
+			store: attempts,
+			now:   time.Now,
+		},
+		now: time.Now,
 	}
 }
 
@@ -59,28 +173,166 @@ var _ UserService = &userService{}
 
 type userService struct {
 	UserDB
+	// uv is the same validator as UserDB, kept so the service
+	// can reach validation steps that aren't part of UserDB
+	uv          *userValidator
+	hmac        *hash.Keyring
+	pwResetDB   pwResetDB
+	magicLinkDB magicLinkDB
+	recoveryDB  recoveryCodeDB
+	// sessionDB is used to log users out when their account
+	// is disabled
+	sessionDB SessionDB
+	throttle  *loginThrottle
+	now       func() time.Time
+}
+
+// Authenticate will verify the email and password. Failed
+// attempts are tracked per account, and once an account has
+// failed too often further attempts are delayed and then
+// locked out with ErrTooManyAttempts.
+func (us *userService) Authenticate(email, password string) (*User, error) {
+	return us.AuthenticateFrom("", email, password)
 }
 
-func (us *userService) Authenticate(email, password string) (*User, error) {
+// AuthenticateFrom works like Authenticate, but additionally
+// tracks failures for the client IP the attempt came from so a
+// single client can't spread its guesses across accounts.
+func (us *userService) AuthenticateFrom(ip, email, password string) (*User, error) {
+	keys := []string{accountKey(strings.ToLower(strings.TrimSpace(email)))}
+	policies := []throttlePolicy{accountThrottle}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+		policies = append(policies, ipThrottle)
+	}
+	for i, key := range keys {
+		if err := us.throttle.allow(key, policies[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := us.authenticate(email, password)
+	switch err {
+	case nil:
+		if err := us.throttle.reset(keys[0]); err != nil {
+			return nil, err
+		}
+		return user, nil
+	case ErrNotFound, ErrPasswordInCorrect:
+		for _, key := range keys {
+			if err := us.throttle.fail(key); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	default:
+		return nil, err
+	}
+}
+
+func (us *userService) authenticate(email, password string) (*User, error) {
 	foundUser, err := us.ByEmail(email)
 	if err != nil {
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+userPwPaper))
-
+	ok, err := us.uv.hasher.Verify(password+us.uv.pepper, foundUser.PasswordHash)
 	if err != nil {
-		switch err {
-		case bcrypt.ErrMismatchedHashAndPassword:
-			return nil, ErrPasswordInCorrect
-		case nil:
-		default:
-			return nil, err
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrPasswordInCorrect
+	}
+	// only tell people the account is disabled once they have
+	// proven it's theirs
+	if foundUser.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+
+	if us.uv.hasher.NeedsRehash(foundUser.PasswordHash) {
+		// This is the only time we know the plain password, so
+		// use it to move the user on to our current hashing
+		// settings. The old hash still works, so if this fails
+		// we simply try again on their next login.
+		foundUser.Password = password
+		if err := us.Update(foundUser); err != nil {
+			foundUser.Password = ""
 		}
 	}
 	return foundUser, nil
 }
 
+func (us *userService) InitiateReset(email string) (*User, string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	// only the most recently requested token should work
+	if err := us.pwResetDB.DeleteByUserID(user.ID); err != nil {
+		return nil, "", err
+	}
+	pwr := pwReset{
+		UserID: user.ID,
+	}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return nil, "", err
+	}
+	return user, pwr.Token, nil
+}
+
+func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+	// check the password first, so a typo doesn't use up the token
+	err := runUserValidatorFunc(&User{Password: newPw},
+		us.uv.passwordRequired,
+		us.uv.passwordMinLength)
+	if err != nil {
+		return nil, err
+	}
+	// tokens are single use, even if they turn out to be expired
+	pwr, err := us.pwResetDB.Use(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if pwr.expired(us.now()) {
+		return nil, ErrTokenInvalid
+	}
+	user, err := us.ByID(pwr.UserID)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	user.Password = newPw
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	// proving ownership of the account lifts any lockout on it
+	if err := us.throttle.reset(accountKey(user.Email)); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (us *userService) ForceReset(user *User) (string, error) {
+	password, err := rand.String(32)
+	if err != nil {
+		return "", err
+	}
+	user.Password = password
+	if err := us.Update(user); err != nil {
+		return "", err
+	}
+	_, token, err := us.InitiateReset(user.Email)
+	return token, err
+}
+
 type userValidatorFunc func(*User) error
 
 func runUserValidatorFunc(user *User, fns ...userValidatorFunc) error {
@@ -94,17 +346,19 @@ func runUserValidatorFunc(user *User, fns ...userValidatorFunc) error {
 
 var _ UserDB = &userValidator{}
 
-func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
+func newUserValidator(udb UserDB, pepper string, hasher hash.PasswordHasher) *userValidator {
 	return &userValidator{
 		UserDB:     udb,
-		hmac:       hmac,
+		pepper:     pepper,
+		hasher:     hasher,
 		emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
 	}
 }
 
 type userValidator struct {
 	UserDB
-	hmac       hash.HMAC
+	pepper     string
+	hasher     hash.PasswordHasher
 	emailRegex *regexp.Regexp
 }
 
@@ -121,33 +375,20 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 	return uv.UserDB.ByEmail(user.Email)
 }
 
-func (uv *userValidator) ByRemember(token string) (*User, error) {
-	user := User{
-		Remember: token,
-	}
-	err := runUserValidatorFunc(&user, uv.hmacRemember)
-	if err != nil {
-		return nil, err
-	}
-	return uv.UserDB.ByRemember(user.RememberHash)
-}
-
 // Create will create the provided user and backfill data
 // like the ID, CreatedAt, and UpdatedAt fields
 func (uv *userValidator) Create(user *User) error {
 	err := runUserValidatorFunc(user,
 		uv.passwordRequired,
 		uv.passwordMinLength,
-		uv.bcryptPassword,
+		uv.hashPassword,
 		uv.passwordHashRequired,
-		uv.setRememberIfUnset,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.emailNormalizer,
 		uv.emailRequired,
 		uv.emailFormat,
-		uv.emailIsAvailable)
+		uv.emailIsAvailable,
+		uv.roleDefault,
+		uv.roleValid)
 	if err != nil {
 		return err
 	}
@@ -159,11 +400,26 @@ func (uv *userValidator) Create(user *User) error {
 func (uv *userValidator) Update(user *User) error {
 	err := runUserValidatorFunc(user,
 		uv.passwordMinLength,
-		uv.bcryptPassword,
+		uv.hashPassword,
 		uv.passwordHashRequired,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
+		uv.emailNormalizer,
+		uv.emailRequired,
+		uv.emailFormat,
+		uv.emailIsAvailable,
+		uv.emailChangeToPending,
+		uv.roleDefault,
+		uv.roleValid)
+	if err != nil {
+		return err
+	}
+	return uv.UserDB.Update(user)
+}
+
+// confirmEmail saves a user whose email address was just
+// verified. It skips emailChangeToPending, which would
+// otherwise move a freshly confirmed address back to pending.
+func (uv *userValidator) confirmEmail(user *User) error {
+	err := runUserValidatorFunc(user,
 		uv.emailNormalizer,
 		uv.emailRequired,
 		uv.emailFormat,
@@ -185,64 +441,22 @@ func (uv *userValidator) Delete(id uint) error {
 	return uv.UserDB.Delete(id)
 }
 
-// bcrypt password will hash a user's password
-// with a pre-defined pepper (userPwPepper) and bcrypt
-// if the password field is not an empty string
-func (uv *userValidator) bcryptPassword(user *User) error {
+// hashPassword will hash a user's password with the
+// configured pepper and PasswordHasher if the password field
+// is not an empty string
+func (uv *userValidator) hashPassword(user *User) error {
 	if user.Password == "" {
 		return nil
 	}
-	pwBytes := []byte(user.Password + userPwPaper)
-	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	hashed, err := uv.hasher.Hash(user.Password + uv.pepper)
 	if err != nil {
 		return err
 	}
-	user.PasswordHash = string(hashedBytes)
+	user.PasswordHash = hashed
 	user.Password = ""
 	return nil
 }
 
-func (uv *userValidator) hmacRemember(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	user.RememberHash = uv.hmac.Hash(user.Remember)
-	return nil
-}
-
-func (uv *userValidator) setRememberIfUnset(user *User) error {
-	if user.Remember != "" {
-		return nil
-	}
-	token, err := rand.RememberToken()
-	if err != nil {
-		return err
-	}
-	user.Remember = token
-	return nil
-}
-
-func (uv *userValidator) rememberMinBytes(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	n, err := rand.NBytes(user.Remember)
-	if err != nil {
-		return err
-	}
-	if n < 32 {
-		return ErrRememberTooShort
-	}
-	return nil
-}
-
-func (uv *userValidator) rememberHashRequired(user *User) error {
-	if user.RememberHash == "" {
-		return ErrRememberRequired
-	}
-	return nil
-}
-
 func (uv *userValidator) idGreaterThan(n uint) userValidatorFunc {
 	return userValidatorFunc(func(user *User) error {
 		if user.ID <= n {
@@ -297,6 +511,38 @@ func (uv *userValidator) emailIsAvailable(user *User) error {
 	return nil
 }
 
+// emailChangeToPending keeps the user's current email in place
+// when an update changes it, and stores the new address in
+// PendingEmail until it has been verified.
+func (uv *userValidator) emailChangeToPending(user *User) error {
+	existing, err := uv.UserDB.ByID(user.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Email == user.Email {
+		return nil
+	}
+	user.PendingEmail = user.Email
+	user.Email = existing.Email
+	return nil
+}
+
+func (uv *userValidator) roleDefault(user *User) error {
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	return nil
+}
+
+func (uv *userValidator) roleValid(user *User) error {
+	for _, role := range Roles {
+		if user.Role == role {
+			return nil
+		}
+	}
+	return ErrRoleInvalid
+}
+
 func (uv *userValidator) passwordMinLength(user *User) error {
 	if user.Password == "" {
 		return nil
@@ -346,13 +592,17 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 	return &user, err
 }
 
-func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
-	var user User
-	err := first(ug.db.Where("remember_hash = ?", rememberHash), &user)
-	if err != nil {
+func (ug *userGorm) Search(query string, limit, offset int) ([]User, error) {
+	var users []User
+	db := ug.db.Order("created_at desc").Limit(limit).Offset(offset)
+	if query != "" {
+		like := containsPattern(strings.ToLower(query))
+		db = db.Where(`LOWER(name) LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\'`, like, like)
+	}
+	if err := db.Find(&users).Error; err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return users, nil
 }
 
 // Create will create the provided user and backfill data
@@ -361,7 +611,7 @@ func (ug *userGorm) Create(user *User) error {
 	return ug.db.Create(user).Error
 }
 
-// Update will hash a remember token if provided
+// Update will save all the fields of the provided user
 func (ug *userGorm) Update(user *User) error {
 	return ug.db.Save(user).Error
 }
@@ -382,3 +632,13 @@ func first(db *gorm.DB, dst interface{}) error {
 	}
 	return err
 }
+
+// likeEscaper escapes the characters LIKE treats specially,
+// using the escape character given with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPattern returns a LIKE pattern matching anything
+// that contains s
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
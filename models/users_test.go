@@ -0,0 +1,185 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"lenslocked.com/hash"
+)
+
+const testPepper = "test-pepper"
+
+// memoryUserDB is a UserDB for tests that keeps users in a map
+type memoryUserDB struct {
+	mu     sync.Mutex
+	nextID uint
+	users  map[uint]User
+}
+
+var _ UserDB = &memoryUserDB{}
+
+func newMemoryUserDB() *memoryUserDB {
+	return &memoryUserDB{users: make(map[uint]User)}
+}
+
+func (db *memoryUserDB) ByID(id uint) (*User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	user, ok := db.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (db *memoryUserDB) ByEmail(email string) (*User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, user := range db.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (db *memoryUserDB) Search(query string, limit, offset int) ([]User, error) {
+	return nil, nil
+}
+
+func (db *memoryUserDB) Create(user *User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.nextID++
+	user.ID = db.nextID
+	db.users[user.ID] = *user
+	return nil
+}
+
+func (db *memoryUserDB) Update(user *User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.users[user.ID] = *user
+	return nil
+}
+
+func (db *memoryUserDB) Delete(id uint) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.users, id)
+	return nil
+}
+
+// testClock is a clock tests can move forward by hand
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// newTestUserService returns a userService that keeps
+// everything in memory and runs on clock
+func newTestUserService(t *testing.T, clock *testClock) (*userService, *memoryUserDB) {
+	t.Helper()
+	kr, err := hash.NewKeyring("1", map[string]string{"1": "test-hmac-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newMemoryUserDB()
+	uv := newUserValidator(db, testPepper, hash.NewBcrypt(bcrypt.MinCost))
+	us := &userService{
+		UserDB:      uv,
+		uv:          uv,
+		hmac:        kr,
+		pwResetDB:   newPwResetValidator(newMemoryPwResetDB(clock), kr),
+		magicLinkDB: newMagicLinkValidator(newMemoryMagicLinkDB(clock), kr),
+		recoveryDB:  newMemoryRecoveryCodeDB(),
+		throttle: &loginThrottle{
+			store: NewMemoryAttemptStore(),
+			now:   clock.now,
+		},
+		now: clock.now,
+	}
+	return us, db
+}
+
+// addTestUser stores a user with the provided password
+func addTestUser(t *testing.T, us *userService, db *memoryUserDB, email, password string) *User {
+	t.Helper()
+	pwHash, err := us.uv.hasher.Hash(password + testPepper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{
+		Email:        email,
+		PasswordHash: pwHash,
+		Role:         RoleUser,
+	}
+	if err := db.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+// memoryRecoveryCodeDB is a recoveryCodeDB for tests
+type memoryRecoveryCodeDB struct {
+	mu    sync.Mutex
+	codes map[string]uint
+}
+
+var _ recoveryCodeDB = &memoryRecoveryCodeDB{}
+
+func newMemoryRecoveryCodeDB() *memoryRecoveryCodeDB {
+	return &memoryRecoveryCodeDB{codes: make(map[string]uint)}
+}
+
+func (db *memoryRecoveryCodeDB) Use(userID uint, codeHash string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if id, ok := db.codes[codeHash]; !ok || id != userID {
+		return ErrNotFound
+	}
+	delete(db.codes, codeHash)
+	return nil
+}
+
+func (db *memoryRecoveryCodeDB) Create(code *recoveryCode) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.codes[code.CodeHash] = code.UserID
+	return nil
+}
+
+func (db *memoryRecoveryCodeDB) DeleteByUserID(userID uint) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for codeHash, id := range db.codes {
+		if id == userID {
+			delete(db.codes, codeHash)
+		}
+	}
+	return nil
+}
+
+func TestContainsPattern(t *testing.T) {
+	tests := map[string]string{
+		"jon":        "%jon%",
+		"100%":       `%100\%%`,
+		"first_last": `%first\_last%`,
+		`back\slash`: `%back\\slash%`,
+		`\%_`:        `%\\\%\_%`,
+	}
+	for s, want := range tests {
+		if got := containsPattern(s); got != want {
+			t.Errorf("containsPattern(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// emailVerifyDuration is how long an email verification
+	// link stays valid once it has been sent.
+	emailVerifyDuration = 72 * time.Hour
+	verifyTokenKind     = "verify"
+)
+
+// VerificationToken returns a signed token that proves the
+// owner of the user's email address clicked our link. If the
+// user has asked to change their email, the token is issued
+// for the pending address instead.
+func (us *userService) VerificationToken(user *User) (string, error) {
+	email := user.Email
+	if user.PendingEmail != "" {
+		email = user.PendingEmail
+	}
+	if user.ID <= 0 {
+		return "", ErrIDInvalid
+	}
+	if email == "" {
+		return "", ErrEmailRequired
+	}
+	return us.signToken(emailVerifyDuration, verifyTokenKind, fmt.Sprint(user.ID), email), nil
+}
+
+// VerifyEmail checks the signature and expiry of the token
+// and then marks the address it was issued for as verified.
+// If that address is the user's pending email it replaces
+// their current one.
+func (us *userService) VerifyEmail(token string) (*User, error) {
+	userID, fields, err := us.parseUserToken(token, verifyTokenKind)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 1 {
+		return nil, ErrTokenInvalid
+	}
+	email := fields[0]
+	user, err := us.ByID(userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+
+	now := us.now()
+	switch email {
+	case user.PendingEmail:
+		user.Email = user.PendingEmail
+		user.PendingEmail = ""
+	case user.Email:
+		if user.Verified() {
+			return user, nil
+		}
+	default:
+		// the link was for an address the user no longer uses
+		return nil, ErrTokenInvalid
+	}
+	user.EmailVerifiedAt = &now
+	if err := us.uv.confirmEmail(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,18 @@
+// Package netutil holds small helpers for working with the
+// network details of requests.
+package netutil
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the IP address the request was sent from,
+// without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,84 @@
+// Package policy decides what a user is allowed to do with a
+// resource. Controllers and middleware ask these functions
+// instead of comparing user IDs and roles themselves, so the
+// rules live in one place. A nil user is a logged out visitor.
+package policy
+
+import "lenslocked.com/models"
+
+// CanAdminister reports whether user can use the admin area
+func CanAdminister(user *models.User) bool {
+	return user != nil && user.IsAdmin()
+}
+
+// CanCreateGallery reports whether user can create galleries.
+// Only users with a verified email address can publish.
+func CanCreateGallery(user *models.User) bool {
+	return user != nil && user.Verified()
+}
+
+// CanViewGallery reports whether user can view gallery.
+// Galleries are public.
+func CanViewGallery(user *models.User, gallery *models.Gallery) bool {
+	return gallery != nil
+}
+
+// CanEditGallery reports whether user can change gallery
+func CanEditGallery(user *models.User, gallery *models.Gallery) bool {
+	if user == nil || gallery == nil {
+		return false
+	}
+	return gallery.UserID == user.ID || user.IsAdmin()
+}
+
+// CanDeleteGallery reports whether user can delete gallery
+func CanDeleteGallery(user *models.User, gallery *models.Gallery) bool {
+	return CanEditGallery(user, gallery)
+}
+
+// CanRevokeAPIToken reports whether user can revoke token
+func CanRevokeAPIToken(user *models.User, token *models.APIToken) bool {
+	if user == nil || token == nil {
+		return false
+	}
+	return token.UserID == user.ID
+}
+
+// Invitation use limits. An invitation from an admin can be
+// shared with a whole team; one from a user is meant for a few
+// friends.
+const (
+	userInvitationMaxUses  = 5
+	adminInvitationMaxUses = 500
+)
+
+// CanInvite reports whether user can invite people to sign up.
+// Only users with a verified email address can send invitations.
+func CanInvite(user *models.User) bool {
+	return user != nil && (user.Verified() || user.IsAdmin())
+}
+
+// MaxInvitationUses is the most people a single invitation
+// from user can be used by
+func MaxInvitationUses(user *models.User) int {
+	if user != nil && user.IsAdmin() {
+		return adminInvitationMaxUses
+	}
+	return userInvitationMaxUses
+}
+
+// CanRevokeInvitation reports whether user can withdraw inv
+func CanRevokeInvitation(user *models.User, inv *models.Invitation) bool {
+	if user == nil || inv == nil {
+		return false
+	}
+	return inv.CreatedByID == user.ID || user.IsAdmin()
+}
+
+// CanRevokeSession reports whether user can log session out
+func CanRevokeSession(user *models.User, session *models.Session) bool {
+	if user == nil || session == nil {
+		return false
+	}
+	return session.UserID == user.ID
+}
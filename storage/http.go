@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// FileServer returns a handler that serves the objects in b,
+// using the request's path as the key. Like http.FileServer it
+// handles Range and conditional requests, and it only reads the
+// parts of an object that are asked for. Objects are served
+// with the content type they were stored with, which browsers
+// are told not to sniff. It is usually used
+// with http.StripPrefix.
+func FileServer(b Blob) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if !ValidKey(key) {
+			http.NotFound(w, r)
+			return
+		}
+		info, err := b.Stat(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidKey) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		// browsers mustn't second guess the type and run an
+		// uploaded file as a script or page
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		rs := NewReadSeeker(r.Context(), b, info)
+		defer rs.Close()
+		http.ServeContent(w, r, key, info.ModTime, rs)
+	})
+}
+
+// ReadSeeker reads an object a range at a time. Seeking is
+// free; a new range is only requested by the first Read after
+// the offset has changed.
+type ReadSeeker struct {
+	ctx    context.Context
+	b      Blob
+	key    string
+	size   int64
+	offset int64
+	rc     io.ReadCloser
+}
+
+// NewReadSeeker returns a ReadSeeker for the object described
+// by info, which should come from Stat. It has to be closed.
+func NewReadSeeker(ctx context.Context, b Blob, info *Info) *ReadSeeker {
+	return &ReadSeeker{
+		ctx:  ctx,
+		b:    b,
+		key:  info.Key,
+		size: info.Size,
+	}
+}
+
+func (rs *ReadSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.rc == nil {
+		rc, _, err := rs.b.GetRange(rs.ctx, rs.key, rs.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		rs.rc = rc
+	}
+	n, err := rs.rc.Read(p)
+	rs.offset += int64(n)
+	return n, err
+}
+
+func (rs *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += rs.offset
+	case io.SeekEnd:
+		offset += rs.size
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: seek to a negative offset")
+	}
+	if offset != rs.offset {
+		rs.Close()
+		rs.offset = offset
+	}
+	return offset, nil
+}
+
+// Close closes the current range, if one is open
+func (rs *ReadSeeker) Close() error {
+	if rs.rc == nil {
+		return nil
+	}
+	err := rs.rc.Close()
+	rs.rc = nil
+	return err
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFileServer(t *testing.T) {
+	b := NewLocal(t.TempDir())
+	ctx := context.Background()
+	if err := b.Put(ctx, "galleries/1/cat.gif", strings.NewReader("GIF89a<script>"), "image/gif"); err != nil {
+		t.Fatal(err)
+	}
+	srv := FileServer(b)
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/galleries/1/cat.gif", nil))
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/gif" {
+		t.Errorf("Content-Type = %q, want %q", got, "image/gif")
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want %q", got, "nosniff")
+	}
+	if body, _ := io.ReadAll(w.Body); string(body) != "GIF89a<script>" {
+		t.Errorf("body = %q", body)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/galleries/1/cat.gif", nil)
+	r.Header.Set("Range", "bytes=3-5")
+	srv.ServeHTTP(w, r)
+	if w.Code != 206 || w.Body.String() != "89a" {
+		t.Errorf("range request: status = %d, body = %q, want 206 and %q", w.Code, w.Body, "89a")
+	}
+
+	for _, path := range []string{"/galleries/1/dog.gif", "/../secret", "/galleries/1/.tmp-x"} {
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 404 {
+			t.Errorf("GET %s: status = %d, want 404", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// tempPrefix starts the names of files that are still being
+	// written. They are never listed.
+	tempPrefix = ".tmp-"
+	// typePrefix starts the name of the file next to each
+	// object that holds its content type
+	typePrefix = ".type-"
+)
+
+// Local is a Blob that keeps objects as files under a
+// directory, with keys as their paths. The content type of
+// each object is kept in a file next to it. Keys whose last
+// segment starts with .tmp- or .type- are reserved for those
+// files, and are not valid.
+type Local struct {
+	dir string
+}
+
+var _ Blob = &Local{}
+
+// NewLocal returns a Blob that stores objects under dir, which
+// is created when the first object is stored
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first, so a failed Put never
+	// replaces an object that is already there
+	tmp, err := writeTemp(filepath.Dir(name), r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	tmpType, err := writeTemp(filepath.Dir(name), strings.NewReader(contentType))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpType)
+	if err := os.Rename(tmpType, typePath(name)); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// writeTemp copies r to a new temporary file in dir and returns
+// its name
+func writeTemp(dir string, r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, *Info, error) {
+	return l.GetRange(ctx, key, 0, -1)
+}
+
+func (l *Local) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, *Info, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, notFound(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, nil, ErrNotFound
+	}
+	if offset < 0 || offset > fi.Size() {
+		f.Close()
+		return nil, nil, ErrInvalidRange
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	var rc io.ReadCloser = f
+	if length >= 0 {
+		rc = &limitedReadCloser{Reader: io.LimitReader(f, length), Closer: f}
+	}
+	return rc, l.info(name, key, fi), nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (*Info, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if fi.IsDir() {
+		return nil, ErrNotFound
+	}
+	return l.info(name, key, fi), nil
+}
+
+// Delete removes the object's files, and then any directories
+// left empty by that
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(typePath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+		// fails, harmlessly, as soon as a directory isn't empty
+		if os.Remove(filepath.Join(l.dir, filepath.FromSlash(dir))) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]Info, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+	// only walk the deepest directory every match has to be in
+	root := l.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = filepath.Join(l.dir, filepath.FromSlash(prefix[:i]))
+	}
+	var infos []Info
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || reservedName(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return notFoundOK(err)
+		}
+		infos = append(infos, *l.info(name, key, fi))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return infos, nil
+}
+
+// path is the file the object with key is stored in
+func (l *Local) path(key string) (string, error) {
+	if !ValidKey(key) || reservedName(path.Base(key)) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// typePath is the file the content type of the object stored
+// in name is kept in
+func typePath(name string) string {
+	return filepath.Join(filepath.Dir(name), typePrefix+filepath.Base(name))
+}
+
+// reservedName reports whether a file is one of ours rather
+// than an object
+func reservedName(base string) bool {
+	return strings.HasPrefix(base, tempPrefix) || strings.HasPrefix(base, typePrefix)
+}
+
+// info describes the object with key, stored in name. Objects
+// stored before content types were kept get one worked out
+// from their extension.
+func (l *Local) info(name, key string, fi fs.FileInfo) *Info {
+	var contentType string
+	if b, err := os.ReadFile(typePath(name)); err == nil {
+		contentType = string(b)
+	} else {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &Info{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: contentType,
+		ModTime:     fi.ModTime(),
+	}
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func notFound(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// notFoundOK ignores files that were deleted while a List was
+// walking the directory
+func notFoundOK(err error) error {
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lenslocked.com/storage"
+	"lenslocked.com/storage/storagetest"
+)
+
+func TestLocal(t *testing.T) {
+	if err := storagetest.TestBlob(storage.NewLocal(t.TempDir())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalReservedKeys(t *testing.T) {
+	b := storage.NewLocal(t.TempDir())
+	ctx := context.Background()
+	if err := b.Put(ctx, "a/b.jpg", strings.NewReader("data"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a/.type-b.jpg", "a/.tmp-123"} {
+		if err := b.Put(ctx, key, strings.NewReader("x"), "text/html"); !errors.Is(err, storage.ErrInvalidKey) {
+			t.Errorf("Put(%q) err = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := b.Stat(ctx, key); !errors.Is(err, storage.ErrInvalidKey) {
+			t.Errorf("Stat(%q) err = %v, want ErrInvalidKey", key, err)
+		}
+	}
+	infos, err := b.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Key != "a/b.jpg" {
+		t.Errorf("List() = %+v, want only a/b.jpg", infos)
+	}
+}
+
+// Files stored before content types were kept get one from
+// their extension
+func TestLocalWithoutContentType(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "old.gif"), []byte("GIF89a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := storage.NewLocal(dir)
+	info, err := b.Stat(context.Background(), "a/old.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "image/gif" {
+		t.Errorf("ContentType = %q, want %q", info.ContentType, "image/gif")
+	}
+}
+
+func TestLocalDeleteRemovesDirectories(t *testing.T) {
+	dir := t.TempDir()
+	b := storage.NewLocal(dir)
+	ctx := context.Background()
+	if err := b.Put(ctx, "a/b/c.jpg", strings.NewReader("data"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "a/b/c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("Delete left its directories behind: %v", err)
+	}
+}
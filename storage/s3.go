@@ -0,0 +1,438 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config describes a bucket in an S3-compatible service,
+// such as AWS S3 or MinIO. Buckets are always addressed with
+// path-style URLs, Endpoint/Bucket/key, which every service
+// supports.
+type S3Config struct {
+	// Endpoint is the base URL of the service, for example
+	// "https://s3.eu-west-1.amazonaws.com"
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Client is used to make requests. It defaults to
+	// http.DefaultClient.
+	Client *http.Client
+}
+
+// S3 is a Blob that keeps objects in an S3 bucket. Requests
+// are signed with AWS Signature Version 4.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+var _ Blob = &S3{}
+
+// NewS3 returns a Blob for the bucket described by cfg
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: S3 endpoint %q needs a scheme and host", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("storage: S3 bucket is required")
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// Put has to know the size and hash of the object before
+// sending it, so r is copied to a temporary file first unless
+// it is already in memory.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	body, size, payloadHash, cleanup, err := spool(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	req, err := s.newRequest(ctx, http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, payloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, *Info, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, *Info, error) {
+	if !ValidKey(key) {
+		return nil, nil, ErrInvalidKey
+	}
+	if offset < 0 {
+		return nil, nil, ErrInvalidRange
+	}
+	if length == 0 {
+		// there's no way to ask S3 for an empty range
+		info, err := s.Stat(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if offset > info.Size {
+			return nil, nil, ErrInvalidRange
+		}
+		return io.NopCloser(bytes.NewReader(nil)), info, nil
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case length > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err == errRangeNotSatisfiable {
+		// S3 won't return an empty range at the very end of an
+		// object, which we allow
+		info, err := s.Stat(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if offset == info.Size {
+			return io.NopCloser(bytes.NewReader(nil)), info, nil
+		}
+		return nil, nil, ErrInvalidRange
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info := s.info(key, resp.Header)
+	if resp.StatusCode == http.StatusPartialContent {
+		// Content-Length is the size of the range, and the size
+		// of the whole object is after the slash in Content-Range
+		cr := resp.Header.Get("Content-Range")
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			info.Size, _ = strconv.ParseInt(cr[i+1:], 10, 64)
+		}
+	}
+	return resp.Body, info, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*Info, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	req, err := s.newRequest(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return s.info(key, resp.Header), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult is the part of a ListObjectsV2 response
+// we use
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Info, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+	var infos []Info
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			infos = append(infos, Info{
+				Key:     c.Key,
+				Size:    c.Size,
+				ModTime: c.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return infos, nil
+}
+
+// newRequest builds a request for key in our bucket, or for
+// the bucket itself when key is empty
+func (s *S3) newRequest(ctx context.Context, method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + "/" + s.cfg.Bucket
+	u.RawPath = s.endpoint.EscapedPath() + "/" + uriEncode(s.cfg.Bucket, false)
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + uriEncode(key, false)
+	}
+	u.RawQuery = canonicalQuery(query)
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+// errRangeNotSatisfiable is returned by do when the requested
+// range starts at or past the end of the object
+var errRangeNotSatisfiable = errors.New("storage: range not satisfiable")
+
+// do signs and sends req. Responses with error statuses are
+// closed and turned into errors.
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, errRangeNotSatisfiable
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("storage: S3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+}
+
+func (s *S3) info(key string, h http.Header) *Info {
+	size, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	modTime, _ := http.ParseTime(h.Get("Last-Modified"))
+	return &Info{
+		Key:         key,
+		Size:        size,
+		ContentType: h.Get("Content-Type"),
+		ModTime:     modTime,
+	}
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds an AWS Signature Version 4 Authorization header
+// to req, signing its method, path, query and the host, date
+// and payload hash headers.
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// uriEncode escapes s the way Signature Version 4 expects:
+// everything but unreserved characters is percent encoded,
+// and slashes too unless they separate path segments.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by key, as both the URL
+// and the signature need it
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// spool returns a seekable copy of r, along with its size and
+// SHA-256, and a function that cleans up the copy. Readers
+// that are already in memory are used as they are.
+func spool(r io.Reader) (io.ReadSeeker, int64, string, func(), error) {
+	noop := func() {}
+	switch r := r.(type) {
+	case *bytes.Reader:
+		b := make([]byte, r.Len())
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, 0, "", noop, err
+		}
+		return bytes.NewReader(b), int64(len(b)), sha256Hex(b), noop, nil
+	case *bytes.Buffer:
+		b := r.Bytes()
+		return bytes.NewReader(b), int64(len(b)), sha256Hex(b), noop, nil
+	case *strings.Reader:
+		b := make([]byte, r.Len())
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, 0, "", noop, err
+		}
+		return bytes.NewReader(b), int64(len(b)), sha256Hex(b), noop, nil
+	}
+	f, err := os.CreateTemp("", "storage-s3-*")
+	if err != nil {
+		return nil, 0, "", noop, err
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		cleanup()
+		return nil, 0, "", noop, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, "", noop, err
+	}
+	return f, size, hex.EncodeToString(h.Sum(nil)), cleanup, nil
+}
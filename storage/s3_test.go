@@ -0,0 +1,43 @@
+package storage_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"lenslocked.com/storage"
+	"lenslocked.com/storage/storagetest"
+)
+
+func TestS3(t *testing.T) {
+	s3 := storagetest.NewS3Server()
+	// small pages, so List has to follow continuation tokens
+	s3.MaxKeys = 2
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	b, err := storage.NewS3(storage.S3Config{
+		Endpoint:        srv.URL,
+		Region:          "us-east-1",
+		Bucket:          "test",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		Client:          srv.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storagetest.TestBlob(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewS3(t *testing.T) {
+	for _, cfg := range []storage.S3Config{
+		{Endpoint: "s3.amazonaws.com", Bucket: "test"},
+		{Endpoint: "https://s3.amazonaws.com"},
+	} {
+		if _, err := storage.NewS3(cfg); err == nil {
+			t.Errorf("NewS3(%+v) err = nil, want an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package storage keeps files, like uploaded images, out of
+// the database. Everything that stores files goes through the
+// Blob interface, so where they end up living is decided by
+// configuration: on the local disk with NewLocal, or in an
+// S3-compatible bucket with NewS3.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when there is no object with the
+	// requested key
+	ErrNotFound = errors.New("storage: object not found")
+	// ErrInvalidKey is returned for keys that ValidKey rejects
+	ErrInvalidKey = errors.New("storage: invalid key")
+	// ErrInvalidRange is returned when a range starts past the
+	// end of the object
+	ErrInvalidRange = errors.New("storage: invalid range")
+)
+
+// Info describes a stored object
+type Info struct {
+	Key  string
+	Size int64
+	// ContentType may be empty in the results of List
+	ContentType string
+	ModTime     time.Time
+}
+
+// Blob stores objects under slash separated keys, such as
+// "galleries/1/sunset.jpg". Implementations are safe for
+// concurrent use.
+type Blob interface {
+	// Put stores everything read from r under key, replacing
+	// any object already there. If reading r fails the error is
+	// returned and the previous object, if any, is left as it
+	// was.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Get returns the contents of the object. The caller has
+	// to close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, *Info, error)
+	// GetRange returns length bytes of the object starting at
+	// offset, or everything from offset when length is negative.
+	// Ranges running past the end of the object are cut short.
+	// The Info describes the whole object.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, *Info, error)
+	Stat(ctx context.Context, key string) (*Info, error)
+	// Delete removes the object. Deleting a key that doesn't
+	// exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns every object whose key starts with prefix,
+	// ordered by key
+	List(ctx context.Context, prefix string) ([]Info, error)
+}
+
+// ValidKey reports whether key can be used with a Blob. Keys
+// are made of non-empty segments separated by single slashes,
+// and can't contain "." or ".." segments or backslashes, so
+// they never escape the directory or bucket they live in.
+func ValidKey(key string) bool {
+	if key == "" || strings.ContainsAny(key, "\\\x00") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// validPrefix reports whether prefix can be passed to List. A
+// prefix is a key, or the start of one, or empty.
+func validPrefix(prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	return ValidKey(strings.TrimSuffix(prefix, "/"))
+}
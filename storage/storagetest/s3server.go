@@ -0,0 +1,208 @@
+package storagetest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Server is an in-memory stand-in for an S3 service. It
+// understands the path-style requests storage.S3 makes: PUT,
+// GET with ranges, HEAD and DELETE of objects, and
+// ListObjectsV2. Buckets spring into existence when first used.
+// Signatures aren't checked, only that requests are signed and
+// that uploads match the payload hash they were signed with.
+type S3Server struct {
+	// MaxKeys limits how many objects a single list response
+	// holds, so paging can be exercised. It defaults to 1000,
+	// like S3.
+	MaxKeys int
+
+	mu      sync.Mutex
+	objects map[string]s3Object
+}
+
+type s3Object struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+var _ http.Handler = &S3Server{}
+
+// NewS3Server returns an empty S3Server. Use it with
+// httptest.NewServer, and the server's URL as the endpoint.
+func NewS3Server() *S3Server {
+	return &S3Server{
+		MaxKeys: 1000,
+		objects: make(map[string]s3Object),
+	}
+}
+
+func (s *S3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+	if bucket == "" {
+		s3Error(w, http.StatusBadRequest, "InvalidBucketName")
+		return
+	}
+	if key == "" {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			s.list(w, r, bucket)
+			return
+		}
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		s.put(w, r, bucket+"/"+key)
+	case http.MethodGet, http.MethodHead:
+		s.get(w, r, bucket+"/"+key)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, bucket+"/"+key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *S3Server) put(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if r.ContentLength != int64(len(data)) {
+		s3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if hash := r.Header.Get("X-Amz-Content-Sha256"); hash != "UNSIGNED-PAYLOAD" {
+		sum := sha256.Sum256(data)
+		if hash != hex.EncodeToString(sum[:]) {
+			s3Error(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+			return
+		}
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	s.mu.Lock()
+	s.objects[name] = s3Object{
+		data:        data,
+		contentType: contentType,
+		// S3 only keeps modification times to the second
+		modTime: time.Now().UTC().Truncate(time.Second),
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *S3Server) get(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	obj, ok := s.objects[name]
+	s.mu.Unlock()
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	w.Header().Set("Content-Type", obj.contentType)
+	// ServeContent handles Range headers the same way S3 does
+	http.ServeContent(w, r, "", obj.modTime, bytes.NewReader(obj.data))
+}
+
+type listResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	ContinuationToken     string        `xml:",omitempty"`
+	NextContinuationToken string        `xml:",omitempty"`
+	Contents              []listContent `xml:"Contents"`
+}
+
+type listContent struct {
+	Key          string
+	LastModified string
+	Size         int64
+}
+
+// list answers ListObjectsV2. Continuation tokens are simply
+// the last key of the previous page.
+func (s *S3Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	after := query.Get("continuation-token")
+	maxKeys := s.MaxKeys
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n < maxKeys {
+		maxKeys = n
+	}
+
+	s.mu.Lock()
+	var keys []string
+	for name := range s.objects {
+		if !strings.HasPrefix(name, bucket+"/") {
+			continue
+		}
+		key := strings.TrimPrefix(name, bucket+"/")
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := listResult{
+		Name:              bucket,
+		Prefix:            prefix,
+		MaxKeys:           maxKeys,
+		ContinuationToken: after,
+	}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		obj := s.objects[bucket+"/"+key]
+		result.Contents = append(result.Contents, listContent{
+			Key:          key,
+			LastModified: obj.modTime.Format("2006-01-02T15:04:05.000Z"),
+			Size:         int64(len(obj.data)),
+		})
+	}
+	s.mu.Unlock()
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
@@ -0,0 +1,385 @@
+// Package storagetest checks that storage.Blob implementations
+// behave the same way, and provides an in-memory stand-in for
+// an S3 service so the S3 backend can be checked without one.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"lenslocked.com/rand"
+	"lenslocked.com/storage"
+)
+
+// TestBlob runs every check in this package against b, and
+// returns an error describing the first one that fails. It
+// only touches keys under a random prefix, which it removes
+// again, so it can safely be pointed at a bucket in use.
+func TestBlob(b storage.Blob) error {
+	suffix, err := rand.Bytes(4)
+	if err != nil {
+		return err
+	}
+	t := &tester{
+		ctx:    context.Background(),
+		b:      b,
+		prefix: fmt.Sprintf("storagetest-%x/", suffix),
+	}
+	defer t.cleanup()
+	checks := []struct {
+		name string
+		fn   func() error
+	}{
+		{"missing objects", t.missing},
+		{"put and get", t.putGet},
+		{"ranges", t.ranges},
+		{"overwrite", t.overwrite},
+		{"content types", t.contentTypes},
+		{"failed put", t.failedPut},
+		{"list", t.list},
+		{"delete", t.delete},
+		{"invalid keys", t.invalidKeys},
+		{"read seeker", t.readSeeker},
+	}
+	for _, check := range checks {
+		if err := check.fn(); err != nil {
+			return fmt.Errorf("storagetest: %s: %v", check.name, err)
+		}
+	}
+	return nil
+}
+
+type tester struct {
+	ctx    context.Context
+	b      storage.Blob
+	prefix string
+}
+
+var content = []byte("The quick brown fox jumps over the lazy dog")
+
+func (t *tester) key(name string) string {
+	return t.prefix + name
+}
+
+func (t *tester) put(name string, data []byte) error {
+	return t.b.Put(t.ctx, t.key(name), bytes.NewReader(data), "image/jpeg")
+}
+
+func (t *tester) read(name string) ([]byte, *storage.Info, error) {
+	rc, info, err := t.b.Get(t.ctx, t.key(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	return data, info, err
+}
+
+func (t *tester) cleanup() {
+	infos, err := t.b.List(t.ctx, t.prefix)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		t.b.Delete(t.ctx, info.Key)
+	}
+}
+
+func (t *tester) missing() error {
+	if _, err := t.b.Stat(t.ctx, t.key("missing.jpg")); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("Stat returned %v, want ErrNotFound", err)
+	}
+	if _, _, err := t.b.Get(t.ctx, t.key("missing.jpg")); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("Get returned %v, want ErrNotFound", err)
+	}
+	if _, _, err := t.b.GetRange(t.ctx, t.key("missing.jpg"), 1, 2); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("GetRange returned %v, want ErrNotFound", err)
+	}
+	infos, err := t.b.List(t.ctx, t.prefix)
+	if err != nil {
+		return fmt.Errorf("List: %v", err)
+	}
+	if len(infos) != 0 {
+		return fmt.Errorf("List returned %d objects under a new prefix, want 0", len(infos))
+	}
+	return nil
+}
+
+func (t *tester) putGet() error {
+	if err := t.put("a.jpg", content); err != nil {
+		return fmt.Errorf("Put: %v", err)
+	}
+	data, info, err := t.read("a.jpg")
+	if err != nil {
+		return fmt.Errorf("Get: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		return fmt.Errorf("Get returned %q, want %q", data, content)
+	}
+	if err := checkInfo(info, t.key("a.jpg"), int64(len(content))); err != nil {
+		return fmt.Errorf("Get: %v", err)
+	}
+	info, err = t.b.Stat(t.ctx, t.key("a.jpg"))
+	if err != nil {
+		return fmt.Errorf("Stat: %v", err)
+	}
+	if err := checkInfo(info, t.key("a.jpg"), int64(len(content))); err != nil {
+		return fmt.Errorf("Stat: %v", err)
+	}
+	if info.ContentType != "image/jpeg" {
+		return fmt.Errorf("Stat returned content type %q, want image/jpeg", info.ContentType)
+	}
+	if info.ModTime.IsZero() {
+		return fmt.Errorf("Stat returned no modification time")
+	}
+	// an empty object is still an object
+	if err := t.put("empty.jpg", nil); err != nil {
+		return fmt.Errorf("Put of an empty object: %v", err)
+	}
+	data, _, err = t.read("empty.jpg")
+	if err != nil || len(data) != 0 {
+		return fmt.Errorf("Get of an empty object returned %q, %v", data, err)
+	}
+	return nil
+}
+
+func checkInfo(info *storage.Info, key string, size int64) error {
+	if info == nil {
+		return fmt.Errorf("returned no info")
+	}
+	if info.Key != key {
+		return fmt.Errorf("returned key %q, want %q", info.Key, key)
+	}
+	if info.Size != size {
+		return fmt.Errorf("returned size %d, want %d", info.Size, size)
+	}
+	return nil
+}
+
+func (t *tester) ranges() error {
+	size := int64(len(content))
+	tests := []struct {
+		offset, length int64
+		want           []byte
+	}{
+		{0, -1, content},
+		{4, 5, content[4:9]},
+		{10, -1, content[10:]},
+		{0, 1, content[:1]},
+		{size - 3, 100, content[size-3:]},
+		{size, -1, nil},
+		{3, 0, nil},
+	}
+	for _, tt := range tests {
+		rc, info, err := t.b.GetRange(t.ctx, t.key("a.jpg"), tt.offset, tt.length)
+		if err != nil {
+			return fmt.Errorf("GetRange(%d, %d): %v", tt.offset, tt.length, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("GetRange(%d, %d): %v", tt.offset, tt.length, err)
+		}
+		if !bytes.Equal(data, tt.want) {
+			return fmt.Errorf("GetRange(%d, %d) returned %q, want %q", tt.offset, tt.length, data, tt.want)
+		}
+		if err := checkInfo(info, t.key("a.jpg"), size); err != nil {
+			return fmt.Errorf("GetRange(%d, %d): %v", tt.offset, tt.length, err)
+		}
+	}
+	if _, _, err := t.b.GetRange(t.ctx, t.key("a.jpg"), size+1, -1); !errors.Is(err, storage.ErrInvalidRange) {
+		return fmt.Errorf("GetRange past the end returned %v, want ErrInvalidRange", err)
+	}
+	return nil
+}
+
+func (t *tester) overwrite() error {
+	replacement := []byte("something else entirely")
+	if err := t.put("a.jpg", replacement); err != nil {
+		return fmt.Errorf("Put: %v", err)
+	}
+	data, info, err := t.read("a.jpg")
+	if err != nil {
+		return fmt.Errorf("Get: %v", err)
+	}
+	if !bytes.Equal(data, replacement) {
+		return fmt.Errorf("Get returned %q after overwriting, want %q", data, replacement)
+	}
+	return checkInfo(info, t.key("a.jpg"), int64(len(replacement)))
+}
+
+// contentTypes checks the content type passed to Put is the
+// one returned, whatever the key's extension suggests
+func (t *tester) contentTypes() error {
+	key := t.key("types/page.html")
+	for _, contentType := range []string{"image/gif", "image/png"} {
+		if err := t.b.Put(t.ctx, key, bytes.NewReader(content), contentType); err != nil {
+			return fmt.Errorf("Put: %v", err)
+		}
+		info, err := t.b.Stat(t.ctx, key)
+		if err != nil {
+			return fmt.Errorf("Stat: %v", err)
+		}
+		if info.ContentType != contentType {
+			return fmt.Errorf("Stat returned content type %q, want %q", info.ContentType, contentType)
+		}
+		_, info, err = t.read("types/page.html")
+		if err != nil {
+			return fmt.Errorf("Get: %v", err)
+		}
+		if info.ContentType != contentType {
+			return fmt.Errorf("Get returned content type %q, want %q", info.ContentType, contentType)
+		}
+		infos, err := t.b.List(t.ctx, t.key("types/"))
+		if err != nil {
+			return fmt.Errorf("List: %v", err)
+		}
+		// List is allowed to leave content types out
+		if len(infos) != 1 || infos[0].ContentType != "" && infos[0].ContentType != contentType {
+			return fmt.Errorf("List returned %+v, want one object of type %q", infos, contentType)
+		}
+	}
+	return nil
+}
+
+// errReader returns some data and then fails
+type errReader struct {
+	data []byte
+}
+
+var errRead = errors.New("read failed")
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errRead
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (t *tester) failedPut() error {
+	if err := t.put("b.jpg", content); err != nil {
+		return fmt.Errorf("Put: %v", err)
+	}
+	err := t.b.Put(t.ctx, t.key("b.jpg"), &errReader{data: []byte("partial")}, "image/jpeg")
+	if !errors.Is(err, errRead) {
+		return fmt.Errorf("Put with a failing reader returned %v, want the reader's error", err)
+	}
+	data, _, err := t.read("b.jpg")
+	if err != nil {
+		return fmt.Errorf("Get: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		return fmt.Errorf("a failed Put changed the object to %q", data)
+	}
+	err = t.b.Put(t.ctx, t.key("c.jpg"), &errReader{data: []byte("partial")}, "image/jpeg")
+	if !errors.Is(err, errRead) {
+		return fmt.Errorf("Put with a failing reader returned %v, want the reader's error", err)
+	}
+	if _, err := t.b.Stat(t.ctx, t.key("c.jpg")); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("a failed Put created an object: Stat returned %v", err)
+	}
+	return nil
+}
+
+func (t *tester) list() error {
+	for _, name := range []string{"list/b/c.jpg", "list/b.jpg", "list/ab.jpg", "list/b/a/z.jpg", "other/x.jpg"} {
+		if err := t.put(name, []byte(name)); err != nil {
+			return fmt.Errorf("Put(%q): %v", name, err)
+		}
+	}
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"list/", []string{"list/ab.jpg", "list/b.jpg", "list/b/a/z.jpg", "list/b/c.jpg"}},
+		{"list/b", []string{"list/b.jpg", "list/b/a/z.jpg", "list/b/c.jpg"}},
+		{"list/b/", []string{"list/b/a/z.jpg", "list/b/c.jpg"}},
+		{"list/nothing", nil},
+		{"nothing/", nil},
+	}
+	for _, tt := range tests {
+		infos, err := t.b.List(t.ctx, t.key(tt.prefix))
+		if err != nil {
+			return fmt.Errorf("List(%q): %v", tt.prefix, err)
+		}
+		var got []string
+		for _, info := range infos {
+			got = append(got, strings.TrimPrefix(info.Key, t.prefix))
+			if info.Size != int64(len(info.Key)-len(t.prefix)) {
+				return fmt.Errorf("List(%q) returned size %d for %q", tt.prefix, info.Size, info.Key)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			return fmt.Errorf("List(%q) returned %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+	return nil
+}
+
+func (t *tester) delete() error {
+	if err := t.b.Delete(t.ctx, t.key("list/b/c.jpg")); err != nil {
+		return fmt.Errorf("Delete: %v", err)
+	}
+	if _, err := t.b.Stat(t.ctx, t.key("list/b/c.jpg")); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("Stat after Delete returned %v, want ErrNotFound", err)
+	}
+	if err := t.b.Delete(t.ctx, t.key("list/b/c.jpg")); err != nil {
+		return fmt.Errorf("Delete of a missing object returned %v, want nil", err)
+	}
+	// the rest of the prefix is untouched
+	if _, err := t.b.Stat(t.ctx, t.key("list/b/a/z.jpg")); err != nil {
+		return fmt.Errorf("Stat of a neighbouring object: %v", err)
+	}
+	return nil
+}
+
+func (t *tester) invalidKeys() error {
+	for _, key := range []string{"", "/a.jpg", "a//b.jpg", "../a.jpg", "a/../../b.jpg", "a/./b.jpg", "a\\b.jpg", "a/"} {
+		if err := t.b.Put(t.ctx, key, bytes.NewReader(content), "image/jpeg"); !errors.Is(err, storage.ErrInvalidKey) {
+			return fmt.Errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := t.b.Stat(t.ctx, key); !errors.Is(err, storage.ErrInvalidKey) {
+			return fmt.Errorf("Stat(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+		if err := t.b.Delete(t.ctx, key); !errors.Is(err, storage.ErrInvalidKey) {
+			return fmt.Errorf("Delete(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+	return nil
+}
+
+func (t *tester) readSeeker() error {
+	if err := t.put("seek.jpg", content); err != nil {
+		return fmt.Errorf("Put: %v", err)
+	}
+	info, err := t.b.Stat(t.ctx, t.key("seek.jpg"))
+	if err != nil {
+		return fmt.Errorf("Stat: %v", err)
+	}
+	rs := storage.NewReadSeeker(t.ctx, t.b, info)
+	defer rs.Close()
+	if end, err := rs.Seek(0, io.SeekEnd); err != nil || end != int64(len(content)) {
+		return fmt.Errorf("Seek to the end returned %d, %v", end, err)
+	}
+	if _, err := rs.Seek(16, io.SeekStart); err != nil {
+		return fmt.Errorf("Seek: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(rs, buf); err != nil || string(buf) != "fox j" {
+		return fmt.Errorf("Read after Seek returned %q, %v", buf, err)
+	}
+	if _, err := rs.Seek(-9, io.SeekCurrent); err != nil {
+		return fmt.Errorf("Seek: %v", err)
+	}
+	rest, err := io.ReadAll(rs)
+	if err != nil || !bytes.Equal(rest, content[12:]) {
+		return fmt.Errorf("Read after seeking back returned %q, %v", rest, err)
+	}
+	return nil
+}
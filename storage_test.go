@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"lenslocked.com/storage/storagetest"
+)
+
+// TestConfiguredStorage checks that the storage backend set up
+// in .config works. It is skipped when there is no .config, so
+// run it where the server is deployed with
+//
+//	go test -run TestConfiguredStorage .
+func TestConfiguredStorage(t *testing.T) {
+	if _, err := os.Stat(".config"); err != nil {
+		t.Skip("no .config to check")
+	}
+	cfg := LoadConfig(true)
+	if err := storagetest.TestBlob(cfg.Storage.Blob()); err != nil {
+		t.Fatal(err)
+	}
+}
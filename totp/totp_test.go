@@ -0,0 +1,132 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed from RFC 6238 Appendix B,
+// "12345678901234567890", encoded in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The test vectors from RFC 6238 Appendix B for SHA1. The RFC
+// uses 8 digit codes, which end in the 6 digit codes below.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, tc := range rfcVectors {
+		got, err := Code(rfcSecret, time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d) err = %v", tc.unix, err)
+		}
+		if got != tc.code {
+			t.Errorf("Code(%d) = %q, want %q", tc.unix, got, tc.code)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	got, err := Code(strings.ToLower(rfcSecret), time.Unix(59, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "287082" {
+		t.Errorf("Code() = %q, want %q", got, "287082")
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", time.Unix(59, 0)); err == nil {
+		t.Errorf("Code() with an invalid secret err = nil, want an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	tests := []struct {
+		name     string
+		at       time.Time
+		skew     int
+		wantOK   bool
+		wantStep int64
+	}{
+		{"current step", now, 1, true, step},
+		{"no skew", now, 0, true, step},
+		{"one step later", now.Add(Period), 1, true, step},
+		{"one step earlier", now.Add(-Period), 1, true, step},
+		{"one step later without skew", now.Add(Period), 0, false, 0},
+		{"two steps later", now.Add(2 * Period), 1, false, 0},
+		{"two steps earlier", now.Add(-2 * Period), 1, false, 0},
+		{"two steps later with more skew", now.Add(2 * Period), 2, true, step},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gotStep, ok := Validate("050471", rfcSecret, tc.at, tc.skew)
+			if ok != tc.wantOK {
+				t.Fatalf("Validate() ok = %v, want %v", ok, tc.wantOK)
+			}
+			if gotStep != tc.wantStep {
+				t.Errorf("Validate() step = %d, want %d", gotStep, tc.wantStep)
+			}
+		})
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	for _, code := range []string{"050 471", " 050471"} {
+		if _, ok := Validate(code, rfcSecret, now, 0); !ok {
+			t.Errorf("Validate(%q) = false, want spaces ignored", code)
+		}
+	}
+	for _, code := range []string{"", "50471", "0504710", "050472", "abcdef"} {
+		if _, ok := Validate(code, rfcSecret, now, 1); ok {
+			t.Errorf("Validate(%q) = true, want false", code)
+		}
+	}
+	if _, ok := Validate("050471", "not base32!", now, 1); ok {
+		t.Errorf("Validate() with an invalid secret = true, want false")
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q isn't base32: %v", secret, err)
+	}
+	if len(key) != SecretBytes {
+		t.Errorf("secret is %d bytes, want %d", len(key), SecretBytes)
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Lenslocked", "jon@example.com", rfcSecret)
+	for _, part := range []string{
+		"otpauth://totp/Lenslocked:jon@example.com?",
+		"secret=" + rfcSecret,
+		"issuer=Lenslocked",
+		"digits=6",
+		"period=30",
+		"algorithm=SHA1",
+	} {
+		if !strings.Contains(got, part) {
+			t.Errorf("URI() = %q, missing %q", got, part)
+		}
+	}
+}
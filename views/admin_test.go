@@ -0,0 +1,88 @@
+package views
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"lenslocked.com/models"
+)
+
+// evilName is what a user could sign up with to attack the
+// admins looking at their account
+const evilName = `<img src=x onerror="alert(1)">`
+
+func TestAdminViewsEscape(t *testing.T) {
+	now := time.Now()
+	user := models.User{
+		Name:           evilName,
+		Email:          "jon@example.com",
+		Role:           models.RoleUser,
+		DisabledAt:     &now,
+		DisabledReason: evilName,
+	}
+	user.ID = 2
+	user.CreatedAt = now
+	gallery := models.Gallery{UserID: 2, Title: evilName}
+	gallery.ID = 3
+	gallery.CreatedAt = now
+	entry := models.AuditEntry{
+		ActorID:    1,
+		Action:     "user.disable",
+		TargetType: "user",
+		TargetID:   2,
+		Details:    evilName,
+		IP:         evilName,
+	}
+	entry.CreatedAt = now
+	session := models.Session{UserID: 2, UserAgent: evilName, IP: "10.0.0.1", LastSeenAt: now}
+	list := func(rows interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"Query":    `"><script>alert(1)</script>`,
+			"Page":     2,
+			"PrevPage": 1,
+			"NextPage": 3,
+			"Rows":     rows,
+		}
+	}
+
+	tests := map[string]interface{}{
+		"admin/users":     list([]models.User{user}),
+		"admin/galleries": list([]models.Gallery{gallery}),
+		"admin/audit":     list([]models.AuditEntry{entry}),
+		"admin/user": map[string]interface{}{
+			"User":     &user,
+			"Sessions": []models.Session{session},
+			"Audit":    []models.AuditEntry{entry},
+		},
+	}
+	for name, yield := range tests {
+		t.Run(name, func(t *testing.T) {
+			body := render(t, name, Data{
+				User:  &models.User{Name: "Admin", Email: "admin@example.com", Role: models.RoleAdmin},
+				Yield: yield,
+			})
+			for _, raw := range []string{evilName, "<script>alert(1)</script>"} {
+				if strings.Contains(body, raw) {
+					t.Errorf("page contains %q unescaped:\n%s", raw, body)
+				}
+			}
+			if name != "admin/audit" && !strings.Contains(body, "&lt;img src=x") {
+				t.Errorf("page doesn't contain the escaped name:\n%s", body)
+			}
+		})
+	}
+}
+
+func TestAdminPagerEscapesQuery(t *testing.T) {
+	body := render(t, "admin/users", Data{
+		Yield: map[string]interface{}{
+			"Query":    "a&page=99 b",
+			"NextPage": 2,
+			"Rows":     []models.User{},
+		},
+	})
+	if !strings.Contains(body, `href="?q=a%26page%3d99%20b&page=2"`) {
+		t.Errorf("search query isn't escaped in the pager link:\n%s", body)
+	}
+}
@@ -1,5 +1,13 @@
 package views
 
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"lenslocked.com/models"
+)
+
 const (
 	AlertLevelError     = "danger"
 	AlertLevelWarning   = "warning"
@@ -17,22 +25,20 @@ type Alert struct {
 // Data is the top level structure that views expect data to come in
 type Data struct {
 	Alert *Alert
-	Yield interface{}
+	User  *models.User
+	// Impersonating is set when an admin is acting as User
+	Impersonating bool
+	// CSRFField is the hidden input forms need to be accepted
+	CSRFField template.HTML
+	Yield     interface{}
 }
 
 func (d *Data) SetAlert(err error) {
-	if pErr, ok := err.(PublicError); ok {
-		d.Alert = &Alert{
-			Level:   AlertLevelError,
-			Message: pErr.Public(),
-		}
-	} else {
-		d.Alert = &Alert{
-			Level:   AlertLevelError,
-			Message: AlertMessageGeneric,
-		}
-	}
-} 
+	d.Alert = &Alert{
+		Level:   AlertLevelError,
+		Message: PublicMessage(err),
+	}
+}
 
 func (d *Data) AlertError(msg string) {
 	d.Alert = &Alert{
@@ -41,6 +47,95 @@ func (d *Data) AlertError(msg string) {
 	}
 }
 
+func (d *Data) AlertSuccess(msg string) {
+	d.Alert = &Alert{
+		Level:   AlertLevelSuccess,
+		Message: msg,
+	}
+}
+
+// RedirectAlert persists the alert in short lived cookies and
+// then redirects, so the alert is rendered by the next page.
+func RedirectAlert(w http.ResponseWriter, r *http.Request, urlStr string, code int, alert Alert) {
+	persistAlert(w, alert)
+	http.Redirect(w, r, urlStr, code)
+}
+
+func persistAlert(w http.ResponseWriter, alert Alert) {
+	expiresAt := time.Now().Add(5 * time.Minute)
+	lvl := http.Cookie{
+		Name:     "alert_level",
+		Value:    alert.Level,
+		Expires:  expiresAt,
+		Path:     "/",
+		HttpOnly: true,
+	}
+	msg := http.Cookie{
+		Name:     "alert_message",
+		Value:    alert.Message,
+		Expires:  expiresAt,
+		Path:     "/",
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &lvl)
+	http.SetCookie(w, &msg)
+}
+
+func clearAlert(w http.ResponseWriter) {
+	lvl := http.Cookie{
+		Name:     "alert_level",
+		Value:    "",
+		Expires:  time.Now(),
+		Path:     "/",
+		HttpOnly: true,
+	}
+	msg := http.Cookie{
+		Name:     "alert_message",
+		Value:    "",
+		Expires:  time.Now(),
+		Path:     "/",
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &lvl)
+	http.SetCookie(w, &msg)
+}
+
+// getAlert returns the alert persisted by RedirectAlert, if any
+func getAlert(r *http.Request) *Alert {
+	lvl, err := r.Cookie("alert_level")
+	if err != nil {
+		return nil
+	}
+	msg, err := r.Cookie("alert_message")
+	if err != nil {
+		return nil
+	}
+	return &Alert{
+		Level:   lvl.Value,
+		Message: msg.Value,
+	}
+}
+
+// FieldErrors maps the name of a form field to the message
+// that should be rendered next to it
+type FieldErrors map[string]string
+
+// Set shows err next to field, using the public message of
+// the error if it has one
+func (fe FieldErrors) Set(field string, err error) {
+	fe[field] = PublicMessage(err)
+}
+
+// PublicMessage is the message users are shown for err. Errors
+// that aren't public get the generic message, so internal
+// details never leak.
+func PublicMessage(err error) string {
+	if pErr, ok := err.(PublicError); ok {
+		return pErr.Public()
+	}
+	return AlertMessageGeneric
+}
+
 type PublicError interface {
 	error
 	Public() string
@@ -0,0 +1,47 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"lenslocked.com/models"
+)
+
+// Image is what templates need to show an image at a size that
+// suits the screen. Src is the smallest variant, and SrcSet
+// lists every variant so browsers can pick the smallest one
+// that is sharp enough. Href is the original.
+type Image struct {
+	Href   string
+	Src    string
+	SrcSet string
+	Alt    string
+}
+
+// NewImages prepares images to be rendered
+func NewImages(images []models.Image) []Image {
+	ret := make([]Image, len(images))
+	for i := range images {
+		ret[i] = Image{
+			Href:   images[i].Path(),
+			Src:    images[i].Thumbnail(),
+			SrcSet: srcSet(images[i].Variants),
+			Alt:    images[i].Filename,
+		}
+	}
+	return ret
+}
+
+// srcSet formats variants, smallest first, as a srcset
+// attribute. Small images can end up with several variants of
+// the same size; only one is listed.
+func srcSet(variants []models.ImageVariant) string {
+	var srcs []string
+	for n, v := range variants {
+		if n > 0 && v.Width == variants[n-1].Width {
+			continue
+		}
+		srcs = append(srcs, fmt.Sprintf("%s %dw", v.Path(), v.Width))
+	}
+	return strings.Join(srcs, ", ")
+}
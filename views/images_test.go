@@ -0,0 +1,98 @@
+package views
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"lenslocked.com/jobs"
+	"lenslocked.com/models"
+	"lenslocked.com/storage"
+)
+
+// galleryImages uploads a 40x30 image to a gallery, makes its
+// variants and returns the gallery's images
+func galleryImages(t *testing.T) []models.Image {
+	t.Helper()
+	presets := []models.VariantPreset{
+		{Name: "thumbnail", MaxWidth: 20, MaxHeight: 20, Quality: 80},
+		{Name: "medium", MaxWidth: 40, MaxHeight: 40, Quality: 80},
+		// images are never enlarged, so this is the same size
+		// as medium
+		{Name: "large", MaxWidth: 80, MaxHeight: 80, Quality: 80},
+	}
+	is := models.NewImageService(storage.NewLocal(t.TempDir()), 1<<20, presets, jobs.NewQueue(jobs.NewMemoryStore(), jobs.Config{}))
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	img.Set(0, 0, color.White)
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := is.Create(1, &buf, "sunset.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.CreateVariants(uploaded); err != nil {
+		t.Fatal(err)
+	}
+	images, err := is.ByGalleryID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return images
+}
+
+func TestNewImages(t *testing.T) {
+	stored := galleryImages(t)
+	images := NewImages(stored)
+	if len(images) != 1 {
+		t.Fatalf("got %d images, want 1", len(images))
+	}
+	got := images[0]
+	name := stored[0].Filename
+	if got.Href != "/images/galleries/1/"+name {
+		t.Errorf("Href = %q", got.Href)
+	}
+	if !strings.HasSuffix(got.Src, "/thumbnail-20x15.png") {
+		t.Errorf("Src = %q, want the thumbnail", got.Src)
+	}
+	srcs := strings.Split(got.SrcSet, ", ")
+	if len(srcs) != 2 {
+		t.Fatalf("SrcSet = %q, want two sizes", got.SrcSet)
+	}
+	if !strings.HasSuffix(srcs[0], "-20x15.png 20w") || !strings.HasSuffix(srcs[1], "-40x30.png 40w") {
+		t.Errorf("SrcSet = %q, want the 20w and 40w variants, smallest first", got.SrcSet)
+	}
+	if got.Alt != name {
+		t.Errorf("Alt = %q, want %q", got.Alt, name)
+	}
+}
+
+func TestNewImagesWithoutVariants(t *testing.T) {
+	images := NewImages([]models.Image{{GalleryID: 1, Filename: "new.jpg"}})
+	if images[0].Src != images[0].Href {
+		t.Errorf("Src = %q, want the original %q", images[0].Src, images[0].Href)
+	}
+	if images[0].SrcSet != "" {
+		t.Errorf("SrcSet = %q, want none", images[0].SrcSet)
+	}
+}
+
+func TestGalleryShowSrcSet(t *testing.T) {
+	images := NewImages(galleryImages(t))
+	body := render(t, "galleries/show", Data{
+		Yield: map[string]interface{}{
+			"Gallery": &models.Gallery{Title: "Sunsets"},
+			"Images":  images,
+		},
+	})
+	if !strings.Contains(body, `srcset="`+images[0].SrcSet+`"`) {
+		t.Errorf("page doesn't contain srcset %q:\n%s", images[0].SrcSet, body)
+	}
+	if !strings.Contains(body, `src="`+images[0].Src+`"`) {
+		t.Errorf("page doesn't contain src %q:\n%s", images[0].Src, body)
+	}
+}
@@ -2,11 +2,13 @@ package views
 
 import (
 	"bytes"
-	"fmt"
+	"html/template"
 	"io"
 	"net/http"
 	"path/filepath"
-	"text/template"
+
+	"github.com/gorilla/csrf"
+	"lenslocked.com/context"
 )
 
 var (
@@ -18,7 +20,6 @@ var (
 func NewView(layout string, files ...string) *View {
 	addTemplatePath(files)
 	addTemplateExt(files)
-	fmt.Println(files)
 	files = append(files, layoutFiles()...)
 
 	t, err := template.ParseFiles(files...)
@@ -38,24 +39,39 @@ type View struct {
 }
 
 func (v *View) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	v.Render(w, nil) 
+	v.Render(w, r, nil)
 }
 
-// Render is used to render the view with the predefined layout
-func (v *View) Render(w http.ResponseWriter, data interface{}) {
+// Render is used to render the view with the predefined layout.
+// Templates are executed with html/template, so data is escaped
+// for wherever it appears in the page.
+// The logged in user, if any, is looked up from the request
+// context so every layout can tell who is viewing the page, and
+// the CSRF field for the request is passed along with it.
+func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{}) {
 	w.Header().Set("Content-Type", "text/html")
-	switch data.(type) {
+	var vd Data
+	switch d := data.(type) {
 	case Data:
-		// do nothing
+		vd = d
 	default:
-		data = Data{
+		vd = Data{
 			Yield: data,
 		}
 	}
+	if alert := getAlert(r); alert != nil && vd.Alert == nil {
+		vd.Alert = alert
+		clearAlert(w)
+	}
+	vd.User = context.User(r.Context())
+	if session := context.Session(r.Context()); session != nil {
+		vd.Impersonating = session.Impersonated()
+	}
+	vd.CSRFField = csrf.TemplateField(r)
 
 	var buff bytes.Buffer
 
-	if err := v.Template.ExecuteTemplate(&buff, v.Layout, data); err != nil {
+	if err := v.Template.ExecuteTemplate(&buff, v.Layout, vd); err != nil {
 		http.Error(w, "Something went wrong, if problem persist contact us", http.StatusInternalServerError)
 		return
 	}
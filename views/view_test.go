@@ -0,0 +1,112 @@
+package views
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+)
+
+func init() {
+	// tests run from the views directory
+	LayoutDir = "layouts/"
+	TemplateDir = ""
+}
+
+// render renders the template with the bootstrap layout and
+// fails the test if it can't be
+func render(t *testing.T, file string, data interface{}) string {
+	t.Helper()
+	v := NewView("bootstrap", file)
+	w := httptest.NewRecorder()
+	v.Render(w, httptest.NewRequest("GET", "/", nil), data)
+	if w.Code != 200 {
+		t.Fatalf("rendering %s: status = %d, body:\n%s", file, w.Code, w.Body)
+	}
+	return w.Body.String()
+}
+
+func TestViewsRender(t *testing.T) {
+	// pages that can't render without some of their data,
+	// every other page is given an empty map
+	yields := map[string]interface{}{
+		"account/settings": map[string]interface{}{"Errors": FieldErrors{}},
+		"account/restore":  time.Now(),
+	}
+	files, err := filepath.Glob("*/*" + TemplateExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f, "layouts/") {
+			continue
+		}
+		name := strings.TrimSuffix(f, TemplateExt)
+		yield, ok := yields[name]
+		if !ok {
+			yield = map[string]interface{}{}
+		}
+		t.Run(name, func(t *testing.T) {
+			render(t, name, yield)
+			render(t, name, Data{
+				Alert: &Alert{Level: AlertLevelError, Message: "<b>alert</b>"},
+				User:  &models.User{Name: "Jon", Email: "jon@example.com"},
+				Yield: yield,
+			})
+		})
+	}
+}
+
+func TestRenderEscapes(t *testing.T) {
+	const evil = `<script>alert("x")</script>`
+	gallery := models.Gallery{Title: evil}
+	gallery.ID = 1
+	images := []models.Image{{GalleryID: 1, Filename: `a"onerror="alert(1).jpg`}}
+	body := render(t, "galleries/show", Data{
+		Alert: &Alert{Level: AlertLevelError, Message: evil},
+		User:  &models.User{Name: evil, Email: "jon@example.com"},
+		Yield: map[string]interface{}{
+			"Gallery": &gallery,
+			"Images":  NewImages(images),
+		},
+	})
+	if strings.Contains(body, evil) {
+		t.Errorf("page contains the unescaped script:\n%s", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;") {
+		t.Errorf("page doesn't contain the escaped title:\n%s", body)
+	}
+	if strings.Contains(body, `"onerror="`) {
+		t.Errorf("filename broke out of its attribute:\n%s", body)
+	}
+}
+
+func TestRenderCSRFField(t *testing.T) {
+	gallery := models.Gallery{Title: "Trip"}
+	gallery.ID = 1
+	v := NewView("bootstrap", "admin/galleries")
+	h := csrf.Protect([]byte("32-byte-long-auth-key-for-tests!"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v.Render(w, r, map[string]interface{}{
+				"Rows": []models.Gallery{gallery, gallery},
+			})
+		}))
+	// an unverified user adds the logout and resend link forms
+	user := &models.User{Name: "Jon", Email: "jon@example.com"}
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithUser(r.Context(), user))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	body := w.Body.String()
+	forms := strings.Count(body, `method="POST"`)
+	fields := strings.Count(body, `name="gorilla.csrf.Token"`)
+	if forms != 4 || fields != forms {
+		t.Errorf("got %d POST forms and %d CSRF fields, want 4 of each:\n%s", forms, fields, body)
+	}
+}